@@ -5,10 +5,17 @@ import (
 	"database/sql"
 	"log"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"ecom/internal/common"
-	"ecom/internal/customers/domain"
+	"ecom/internal/common/admin"
+	"ecom/internal/common/apperr"
+	"ecom/internal/common/idempotency"
+	"ecom/internal/common/migrate"
+	"ecom/internal/common/outbox"
 	"ecom/internal/customers/handler"
 	"ecom/internal/customers/repository"
 	"ecom/internal/customers/service"
@@ -17,6 +24,9 @@ import (
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
+// shutdownTimeout bounds how long in-flight HTTP requests get to finish.
+const shutdownTimeout = 10 * time.Second
+
 func mustEnv(k string) string {
 	v := os.Getenv(k)
 	if v == "" {
@@ -26,13 +36,25 @@ func mustEnv(k string) string {
 }
 
 func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	db, err := sql.Open("pgx", mustEnv("DATABASE_URL"))
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.Close()
 
-	if err := initSchema(db); err != nil {
+	migrator, err := migrate.New(db, repository.Migrations)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrator.Command(ctx, os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := migrator.Up(ctx); err != nil {
 		log.Fatal(err)
 	}
 
@@ -40,68 +62,70 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer bus.Close()
 
 	// Dependency Injection
 	repo := repository.NewPostgresRepository(db)
 	svc := service.NewCustomerService(repo)
 	h := handler.NewCustomerHandler(svc)
 
-	relay := &Relay{repo: repo, bus: bus}
-	go relay.Start()
+	relay, err := outbox.RelayFromEnv(repo, bus)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pruner, err := outbox.PrunerFromEnv(repo)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	idem := idempotency.New(idempotency.NewPostgresStore(db))
+
+	// background work outlives the HTTP drain so requests that were still
+	// writing to the outbox get relayed
+	workCtx, stopWork := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	workers.Go(func() { relay.Run(workCtx) })
+	workers.Go(func() { pruner.Run(workCtx) })
+	workers.Go(func() { idem.Run(workCtx) })
 
-	app := fiber.New()
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.ErrorHandler})
+	app.Use(idem.Handle)
 	h.RegisterRoutes(app)
 
 	addr := mustEnv("HTTP_ADDR")
-	log.Printf("customers listening on %s", addr)
-	log.Fatal(app.Listen(addr))
-}
-
-func initSchema(db *sql.DB) error {
-	_, err := db.Exec(`
-CREATE TABLE IF NOT EXISTS customers (
-  id TEXT PRIMARY KEY,
-  name TEXT NOT NULL,
-  email TEXT NOT NULL,
-  updated_at TIMESTAMP NOT NULL
-);
-
-CREATE TABLE IF NOT EXISTS outbox (
-  id BIGSERIAL PRIMARY KEY,
-  routing_key TEXT NOT NULL,
-  payload JSONB NOT NULL,
-  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
-  processed_at TIMESTAMP
-);
-`)
-	return err
-}
+	go func() {
+		log.Printf("customers listening on %s", addr)
+		if err := app.Listen(addr); err != nil {
+			log.Fatal(err)
+		}
+	}()
 
-type Relay struct {
-	repo domain.Repository
-	bus  *common.Bus
-}
+	// the outbox admin API is kept off the public port
+	adminApp := admin.New(mustEnv("ADMIN_TOKEN"))
+	outbox.NewAdminHandler(repo).RegisterRoutes(adminApp)
 
-func (r *Relay) Start() {
-	ticker := time.NewTicker(2 * time.Second)
-	for range ticker.C {
-		msgs, err := r.repo.GetUnprocessedMessages(context.Background(), 10)
-		if err != nil {
-			log.Printf("relay: get messages: %v", err)
-			continue
+	adminAddr := mustEnv("ADMIN_ADDR")
+	go func() {
+		log.Printf("customers admin listening on %s", adminAddr)
+		if err := adminApp.Listen(adminAddr); err != nil {
+			log.Fatal(err)
 		}
+	}()
 
-		for _, m := range msgs {
-			err := r.bus.Publish(m.RoutingKey, m.Payload)
-			if err != nil {
-				log.Printf("relay: publish %d: %v", m.ID, err)
-				continue
-			}
+	<-ctx.Done()
+	log.Printf("customers shutting down")
 
-			if err := r.repo.MarkMessageProcessed(context.Background(), m.ID); err != nil {
-				log.Printf("relay: mark processed %d: %v", m.ID, err)
-			}
-		}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := app.ShutdownWithContext(shutdownCtx); err != nil {
+		log.Printf("http shutdown: %v", err)
+	}
+	if err := adminApp.ShutdownWithContext(shutdownCtx); err != nil {
+		log.Printf("admin shutdown: %v", err)
+	}
+	stopWork()
+	workers.Wait()
+	bus.Close()
+	if err := db.Close(); err != nil {
+		log.Printf("close db: %v", err)
 	}
 }
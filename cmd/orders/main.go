@@ -1,11 +1,23 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
+	"ecom/internal/catalog/client"
 	"ecom/internal/common"
+	"ecom/internal/common/admin"
+	"ecom/internal/common/apperr"
+	"ecom/internal/common/idempotency"
+	"ecom/internal/common/migrate"
+	"ecom/internal/common/outbox"
 	"ecom/internal/orders/app"
 	"ecom/internal/orders/handler"
 	"ecom/internal/orders/repository"
@@ -15,6 +27,9 @@ import (
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
+// shutdownTimeout bounds how long in-flight HTTP requests get to finish.
+const shutdownTimeout = 10 * time.Second
+
 func mustEnv(k string) string {
 	v := os.Getenv(k)
 	if v == "" {
@@ -24,12 +39,24 @@ func mustEnv(k string) string {
 }
 
 func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	db, err := sql.Open("pgx", mustEnv("DATABASE_URL"))
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.Close()
-	if err := initSchema(db); err != nil {
+	migrator, err := migrate.New(db, repository.Migrations)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrator.Command(ctx, os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := migrator.Up(ctx); err != nil {
 		log.Fatal(err)
 	}
 
@@ -37,57 +64,104 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer bus.Close()
 
 	// Dependency Injection
 	repo := repository.NewPostgresRepository(db)
 	svc := service.NewOrderService(repo)
+	if v := os.Getenv("CATALOG_URL"); v != "" {
+		catalog := client.New(v)
+		if v := os.Getenv("CATALOG_TIMEOUT"); v != "" {
+			if catalog.Timeout, err = time.ParseDuration(v); err != nil {
+				log.Fatalf("invalid CATALOG_TIMEOUT: %v", err)
+			}
+		}
+		svc.Catalog = app.NewCatalogProducts(catalog)
+	}
 	h := handler.NewOrderHandler(svc)
 	consumers := app.NewConsumers(svc, bus)
+	if v := os.Getenv("CONSUMER_PREFETCH"); v != "" {
+		if consumers.Prefetch, err = strconv.Atoi(v); err != nil {
+			log.Fatalf("invalid CONSUMER_PREFETCH: %v", err)
+		}
+	}
+	if v := os.Getenv("CONSUMER_WORKERS"); v != "" {
+		if consumers.Workers, err = strconv.Atoi(v); err != nil {
+			log.Fatalf("invalid CONSUMER_WORKERS: %v", err)
+		}
+	}
+
+	relay, err := outbox.RelayFromEnv(repo, bus)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pruner, err := outbox.PrunerFromEnv(repo)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	inboxPruner := app.NewInboxPruner(svc)
+	if v := os.Getenv("INBOX_RETENTION"); v != "" {
+		if inboxPruner.Retention, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("invalid INBOX_RETENTION: %v", err)
+		}
+	}
+
+	idem := idempotency.New(idempotency.NewPostgresStore(db))
 
 	// Start Consumers
-	consumers.Start()
+	if err := consumers.Start(); err != nil {
+		log.Fatal(err)
+	}
+
+	// background work outlives the HTTP drain so requests that were still
+	// writing to the outbox get relayed
+	workCtx, stopWork := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	workers.Go(func() { relay.Run(workCtx) })
+	workers.Go(func() { pruner.Run(workCtx) })
+	workers.Go(func() { inboxPruner.Run(workCtx) })
+	workers.Go(func() { idem.Run(workCtx) })
 
-	appFiber := fiber.New()
+	appFiber := fiber.New(fiber.Config{ErrorHandler: apperr.ErrorHandler})
+	appFiber.Use(idem.Handle)
 	h.RegisterRoutes(appFiber)
 
 	addr := mustEnv("HTTP_ADDR")
-	log.Printf("orders listening on %s", addr)
-	log.Fatal(appFiber.Listen(addr))
-}
+	go func() {
+		log.Printf("orders listening on %s", addr)
+		if err := appFiber.Listen(addr); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	// the outbox admin API is kept off the public port
+	adminApp := admin.New(mustEnv("ADMIN_TOKEN"))
+	outbox.NewAdminHandler(repo).RegisterRoutes(adminApp)
+
+	adminAddr := mustEnv("ADMIN_ADDR")
+	go func() {
+		log.Printf("orders admin listening on %s", adminAddr)
+		if err := adminApp.Listen(adminAddr); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Printf("orders shutting down")
 
-func initSchema(db *sql.DB) error {
-	_, err := db.Exec(`
-CREATE TABLE IF NOT EXISTS orders (
-  id TEXT PRIMARY KEY,
-  customer_id TEXT NOT NULL,
-  created_at TIMESTAMP NOT NULL,
-  status TEXT NOT NULL
-);
-
-CREATE TABLE IF NOT EXISTS order_items (
-  order_id TEXT NOT NULL,
-  product_id TEXT NOT NULL,
-  quantity INT NOT NULL,
-  unit_price BIGINT NOT NULL,
-  PRIMARY KEY(order_id, product_id)
-);
-
--- local read model caches (for joining)
-CREATE TABLE IF NOT EXISTS customers_cache (
-  id TEXT PRIMARY KEY,
-  name TEXT,
-  email TEXT,
-  updated_at TIMESTAMP NOT NULL
-);
-
-CREATE TABLE IF NOT EXISTS products_cache (
-  id TEXT PRIMARY KEY,
-  title TEXT,
-  sku TEXT,
-  price BIGINT,
-  updated_at TIMESTAMP NOT NULL
-);
-`)
-	return err
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := appFiber.ShutdownWithContext(shutdownCtx); err != nil {
+		log.Printf("http shutdown: %v", err)
+	}
+	if err := adminApp.ShutdownWithContext(shutdownCtx); err != nil {
+		log.Printf("admin shutdown: %v", err)
+	}
+	consumers.Stop()
+	stopWork()
+	workers.Wait()
+	bus.Close()
+	if err := db.Close(); err != nil {
+		log.Printf("close db: %v", err)
+	}
 }
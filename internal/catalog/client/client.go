@@ -0,0 +1,105 @@
+// Package client is an HTTP client for the catalog service, for services
+// that cannot wait for catalog events to reach them.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"ecom/internal/catalog/domain"
+	"ecom/internal/common/apperr"
+	"ecom/internal/common/breaker"
+)
+
+// Client calls the catalog at BaseURL. Each request is bounded by Timeout,
+// and Breaker stops calls for a while once the catalog keeps failing;
+// failed and rejected calls both come back as apperr unavailable errors.
+type Client struct {
+	BaseURL string
+	Timeout time.Duration
+	Breaker *breaker.Breaker
+	HTTP    *http.Client
+}
+
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		Timeout: 2 * time.Second,
+		Breaker: breaker.New(),
+		HTTP:    http.DefaultClient,
+	}
+}
+
+// GetBatch looks up products by ID with POST /products/batch, splitting
+// ids into batches the catalog accepts.
+func (c *Client) GetBatch(ctx context.Context, ids []string) (*domain.ProductBatch, error) {
+	out := &domain.ProductBatch{Products: []domain.Product{}, Missing: []string{}}
+	for chunk := range slices.Chunk(ids, domain.MaxBatchSize) {
+		batch, err := c.getBatch(ctx, chunk)
+		if err != nil {
+			return nil, err
+		}
+		out.Products = append(out.Products, batch.Products...)
+		out.Missing = append(out.Missing, batch.Missing...)
+	}
+	return out, nil
+}
+
+func (c *Client) getBatch(ctx context.Context, ids []string) (*domain.ProductBatch, error) {
+	if err := c.Breaker.Allow(); err != nil {
+		return nil, unavailable(err)
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	body, err := json.Marshal(map[string]any{"ids": ids})
+	if err != nil {
+		c.Breaker.Success() // not the catalog's fault
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, c.BaseURL+"/products/batch", bytes.NewReader(body))
+	if err != nil {
+		c.Breaker.Success()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			// the caller gave up, which says nothing about the catalog
+			c.Breaker.Release()
+		} else {
+			c.Breaker.Failure()
+		}
+		return nil, unavailable(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		c.Breaker.Failure()
+		return nil, unavailable(fmt.Errorf("POST /products/batch: %s", resp.Status))
+	}
+	// Anything else means the catalog is up, even if we asked wrongly.
+	c.Breaker.Success()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("catalog: POST /products/batch: %s", resp.Status)
+	}
+
+	var batch domain.ProductBatch
+	if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+		return nil, fmt.Errorf("catalog: decode batch: %w", err)
+	}
+	return &batch, nil
+}
+
+func unavailable(err error) error {
+	return apperr.Unavailable("catalog_unavailable", "catalog service is unavailable", err)
+}
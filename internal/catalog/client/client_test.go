@@ -0,0 +1,53 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetBatchBreaker(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeout  time.Duration // of the client
+		cancel   bool          // the caller gives up mid-call
+		wantOpen bool
+	}{
+		{name: "caller cancels", timeout: time.Minute, cancel: true},
+		{name: "catalog too slow", timeout: 10 * time.Millisecond, wantOpen: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-release:
+				case <-r.Context().Done():
+				}
+				w.Write([]byte(`{"products":[],"missing":[]}`))
+			}))
+			defer srv.Close()
+			defer close(release)
+
+			c := New(srv.URL)
+			c.Timeout = tt.timeout
+			c.Breaker.Threshold = 1
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				time.AfterFunc(10*time.Millisecond, cancel)
+			}
+			if _, err := c.GetBatch(ctx, []string{"p1"}); err == nil {
+				t.Fatal("GetBatch succeeded, want an error")
+			}
+
+			if err := c.Breaker.Allow(); (err != nil) != tt.wantOpen {
+				t.Errorf("breaker open = %v, want %v", err != nil, tt.wantOpen)
+			}
+		})
+	}
+}
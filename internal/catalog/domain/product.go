@@ -3,6 +3,15 @@ package domain
 import (
 	"context"
 	"time"
+
+	"ecom/internal/common/apperr"
+	"ecom/internal/common/outbox"
+	"ecom/internal/common/page"
+)
+
+var (
+	ErrProductNotFound        = apperr.NotFound("product_not_found", "product not found")
+	ErrProductVersionConflict = apperr.Conflict("product_version_conflict", "product has been changed since the given version")
 )
 
 type Product struct {
@@ -10,22 +19,50 @@ type Product struct {
 	Title     string    `json:"title"`
 	SKU       string    `json:"sku"`
 	Price     int64     `json:"price"` // cents
+	Version   int64     `json:"version"`
 	UpdatedAt time.Time `json:"updatedAt"`
+	// Set on deleted products. They are kept for their version but are
+	// no longer returned.
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+// MaxBatchSize caps the number of IDs in one batch lookup.
+const MaxBatchSize = 100
+
+// ProductBatch answers a batch lookup. Both lists follow the order of the
+// requested IDs.
+type ProductBatch struct {
+	Products []Product `json:"products"`
+	Missing  []string  `json:"missing"`
+}
+
+// ProductFilter narrows a product listing; zero values match everything.
+type ProductFilter struct {
+	MinPrice  *int64
+	MaxPrice  *int64
+	SKUPrefix string
 }
 
 type Repository interface {
 	SaveWithOutbox(ctx context.Context, p *Product, msg *OutboxMessage) error
+	// UpdateWithOutbox overwrites the stored product, which must still be at
+	// version p.Version-1, or fails with ErrProductVersionConflict.
+	UpdateWithOutbox(ctx context.Context, p *Product, msg *OutboxMessage) error
 	Get(ctx context.Context, id string) (*Product, error)
+	// GetBatch returns the products found among ids, in the order of ids.
 	GetBatch(ctx context.Context, ids []string) ([]Product, error)
+	// List pages through the products sorted by title, price or updatedAt.
+	List(ctx context.Context, f ProductFilter, r page.Request) (*page.Page[Product], error)
 
 	// Outbox methods
-	GetUnprocessedMessages(ctx context.Context, limit int) ([]OutboxMessage, error)
-	MarkMessageProcessed(ctx context.Context, id int64) error
+	ClaimMessages(ctx context.Context, limit int, publish func(msgs []OutboxMessage) []OutboxResult) error
+	PruneMessages(ctx context.Context, before time.Time, limit int, archive bool) (int64, error)
+	ListMessages(ctx context.Context, f outbox.Filter) ([]OutboxMessage, error)
+	GetMessage(ctx context.Context, id int64) (*OutboxMessage, error)
+	RequeueMessages(ctx context.Context, ids []int64) (int64, error)
 }
 
-type OutboxMessage struct {
-	ID         int64
-	RoutingKey string
-	Payload    []byte
-	CreatedAt  time.Time
-}
+type (
+	OutboxMessage = outbox.Message
+	OutboxResult  = outbox.Result
+)
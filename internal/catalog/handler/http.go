@@ -2,9 +2,13 @@ package handler
 
 import (
 	"context"
+	"strconv"
 	"time"
 
+	"ecom/internal/catalog/domain"
 	"ecom/internal/catalog/service"
+	"ecom/internal/common/apperr"
+	"ecom/internal/common/page"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -18,9 +22,13 @@ func NewCatalogHandler(svc *service.CatalogService) *CatalogHandler {
 }
 
 func (h *CatalogHandler) RegisterRoutes(app fiber.Router) {
+	app.Get("/products", h.List)
 	app.Post("/products", h.Create)
 	app.Post("/products/batch", h.GetBatch)
 	app.Get("/products/:id", h.Get)
+	app.Put("/products/:id", h.Replace)
+	app.Patch("/products/:id", h.Update)
+	app.Delete("/products/:id", h.Delete)
 }
 
 func (h *CatalogHandler) Create(c *fiber.Ctx) error {
@@ -30,7 +38,7 @@ func (h *CatalogHandler) Create(c *fiber.Ctx) error {
 		Price int64  `json:"price"`
 	}
 	if err := c.BodyParser(&in); err != nil {
-		return c.Status(400).SendString(err.Error())
+		return apperr.Validation("invalid_body", "request body is not valid JSON")
 	}
 
 	ctx, cancel := context.WithTimeout(c.Context(), 2*time.Second)
@@ -38,29 +46,60 @@ func (h *CatalogHandler) Create(c *fiber.Ctx) error {
 
 	p, err := h.svc.CreateProduct(ctx, in.Title, in.SKU, in.Price)
 	if err != nil {
-		return c.Status(500).SendString(err.Error())
+		return err
 	}
 
 	return c.JSON(p)
 }
 
+// List accepts limit, cursor, sort (title, price or updatedAt, prefixed
+// with - for descending), minPrice, maxPrice and skuPrefix query
+// parameters.
+func (h *CatalogHandler) List(c *fiber.Ctx) error {
+	f := domain.ProductFilter{SKUPrefix: c.Query("skuPrefix")}
+	var err error
+	if f.MinPrice, err = queryInt64(c, "minPrice"); err != nil {
+		return err
+	}
+	if f.MaxPrice, err = queryInt64(c, "maxPrice"); err != nil {
+		return err
+	}
+	r := page.Request{
+		Limit:  c.QueryInt("limit"),
+		Cursor: c.Query("cursor"),
+		Sort:   c.Query("sort"),
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 2*time.Second)
+	defer cancel()
+
+	products, err := h.svc.ListProducts(ctx, f, r)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(products)
+}
+
+// GetBatch takes {"ids": [...]} and answers with the products found and
+// the IDs that were not.
 func (h *CatalogHandler) GetBatch(c *fiber.Ctx) error {
 	var in struct {
 		IDs []string `json:"ids"`
 	}
 	if err := c.BodyParser(&in); err != nil {
-		return c.Status(400).SendString(err.Error())
+		return apperr.Validation("invalid_body", "request body is not valid JSON")
 	}
 
 	ctx, cancel := context.WithTimeout(c.Context(), 2*time.Second)
 	defer cancel()
 
-	products, err := h.svc.GetProductsBatch(ctx, in.IDs)
+	batch, err := h.svc.GetProductsBatch(ctx, in.IDs)
 	if err != nil {
-		return c.Status(500).SendString(err.Error())
+		return err
 	}
 
-	return c.JSON(products)
+	return c.JSON(batch)
 }
 
 func (h *CatalogHandler) Get(c *fiber.Ctx) error {
@@ -70,11 +109,84 @@ func (h *CatalogHandler) Get(c *fiber.Ctx) error {
 
 	p, err := h.svc.GetProduct(ctx, id)
 	if err != nil {
-		if err.Error() == "not found" {
-			return c.Status(404).SendString("not found")
-		}
-		return c.Status(500).SendString(err.Error())
+		return err
 	}
 
 	return c.JSON(p)
 }
+
+type productChanges struct {
+	Title   *string `json:"title"`
+	SKU     *string `json:"sku"`
+	Price   *int64  `json:"price"`
+	Version *int64  `json:"version"`
+}
+
+// Replace takes a complete product. Its optional version must match the
+// stored one.
+func (h *CatalogHandler) Replace(c *fiber.Ctx) error {
+	var in productChanges
+	if err := c.BodyParser(&in); err != nil {
+		return apperr.Validation("invalid_body", "request body is not valid JSON")
+	}
+
+	var fields []apperr.FieldError
+	if in.Title == nil {
+		fields = append(fields, apperr.FieldError{Field: "title", Message: "is required"})
+	}
+	if in.SKU == nil {
+		fields = append(fields, apperr.FieldError{Field: "sku", Message: "is required"})
+	}
+	if in.Price == nil {
+		fields = append(fields, apperr.FieldError{Field: "price", Message: "is required"})
+	}
+	if len(fields) > 0 {
+		return apperr.Validation("invalid_product", "product is incomplete", fields...)
+	}
+
+	return h.update(c, in)
+}
+
+// Update changes only the fields present in the body.
+func (h *CatalogHandler) Update(c *fiber.Ctx) error {
+	var in productChanges
+	if err := c.BodyParser(&in); err != nil {
+		return apperr.Validation("invalid_body", "request body is not valid JSON")
+	}
+	return h.update(c, in)
+}
+
+func (h *CatalogHandler) update(c *fiber.Ctx, in productChanges) error {
+	ctx, cancel := context.WithTimeout(c.Context(), 2*time.Second)
+	defer cancel()
+
+	p, err := h.svc.UpdateProduct(ctx, c.Params("id"), service.ProductChanges(in))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(p)
+}
+
+func (h *CatalogHandler) Delete(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.Context(), 2*time.Second)
+	defer cancel()
+
+	if err := h.svc.DeleteProduct(ctx, c.Params("id")); err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func queryInt64(c *fiber.Ctx, name string) (*int64, error) {
+	s := c.Query(name)
+	if s == "" {
+		return nil, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return nil, apperr.Validation("invalid_query", "invalid filter", apperr.FieldError{Field: name, Message: "must be an integer"})
+	}
+	return &n, nil
+}
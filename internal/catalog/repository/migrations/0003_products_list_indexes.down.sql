@@ -0,0 +1,4 @@
+DROP INDEX products_title_idx;
+DROP INDEX products_price_idx;
+DROP INDEX products_updated_at_idx;
+DROP INDEX products_sku_prefix_idx;
@@ -0,0 +1,5 @@
+-- keyset pagination for GET /products, one index per sort
+CREATE INDEX products_title_idx ON products (title, id) WHERE deleted_at IS NULL;
+CREATE INDEX products_price_idx ON products (price, id) WHERE deleted_at IS NULL;
+CREATE INDEX products_updated_at_idx ON products (updated_at, id) WHERE deleted_at IS NULL;
+CREATE INDEX products_sku_prefix_idx ON products (sku text_pattern_ops) WHERE deleted_at IS NULL;
@@ -3,16 +3,26 @@ package repository
 import (
 	"context"
 	"database/sql"
-	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
 	"ecom/internal/catalog/domain"
+	"ecom/internal/common/outbox"
+	"ecom/internal/common/page"
 )
 
 type PostgresRepository struct {
+	*outbox.PostgresStore
 	db *sql.DB
 }
 
 func NewPostgresRepository(db *sql.DB) *PostgresRepository {
-	return &PostgresRepository{db: db}
+	return &PostgresRepository{
+		PostgresStore: outbox.NewPostgresStore(db),
+		db:            db,
+	}
 }
 
 func (r *PostgresRepository) SaveWithOutbox(ctx context.Context, p *domain.Product, msg *domain.OutboxMessage) error {
@@ -23,58 +33,56 @@ func (r *PostgresRepository) SaveWithOutbox(ctx context.Context, p *domain.Produ
 	defer tx.Rollback()
 
 	_, err = tx.ExecContext(ctx,
-		`INSERT INTO products(id, title, sku, price, updated_at) VALUES ($1,$2,$3,$4,$5)`,
-		p.ID, p.Title, p.SKU, p.Price, p.UpdatedAt,
+		`INSERT INTO products(id, title, sku, price, version, updated_at) VALUES ($1,$2,$3,$4,$5,$6)`,
+		p.ID, p.Title, p.SKU, p.Price, p.Version, p.UpdatedAt,
 	)
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.ExecContext(ctx,
-		`INSERT INTO outbox(routing_key, payload) VALUES ($1,$2)`,
-		msg.RoutingKey, msg.Payload,
-	)
-	if err != nil {
+	if err := outbox.Enqueue(ctx, tx, msg); err != nil {
 		return err
 	}
 
 	return tx.Commit()
 }
 
-func (r *PostgresRepository) GetUnprocessedMessages(ctx context.Context, limit int) ([]domain.OutboxMessage, error) {
-	rows, err := r.db.QueryContext(ctx,
-		`SELECT id, routing_key, payload, created_at FROM outbox WHERE processed_at IS NULL ORDER BY created_at ASC LIMIT $1`,
-		limit,
-	)
+func (r *PostgresRepository) UpdateWithOutbox(ctx context.Context, p *domain.Product, msg *domain.OutboxMessage) error {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	var msgs []domain.OutboxMessage
-	for rows.Next() {
-		var m domain.OutboxMessage
-		if err := rows.Scan(&m.ID, &m.RoutingKey, &m.Payload, &m.CreatedAt); err != nil {
-			return nil, err
-		}
-		msgs = append(msgs, m)
+	res, err := tx.ExecContext(ctx, `
+UPDATE products
+SET title = $2, sku = $3, price = $4, version = $5, updated_at = $6, deleted_at = $7
+WHERE id = $1 AND version = $5 - 1 AND deleted_at IS NULL
+`, p.ID, p.Title, p.SKU, p.Price, p.Version, p.UpdatedAt, p.DeletedAt)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return domain.ErrProductVersionConflict
+	}
+
+	if err := outbox.Enqueue(ctx, tx, msg); err != nil {
+		return err
 	}
-	return msgs, nil
-}
 
-func (r *PostgresRepository) MarkMessageProcessed(ctx context.Context, id int64) error {
-	_, err := r.db.ExecContext(ctx, `UPDATE outbox SET processed_at = NOW() WHERE id = $1`, id)
-	return err
+	return tx.Commit()
 }
 
 func (r *PostgresRepository) Get(ctx context.Context, id string) (*domain.Product, error) {
 	var p domain.Product
 	err := r.db.QueryRowContext(ctx,
-		`SELECT id, title, sku, price FROM products WHERE id=$1`, id,
-	).Scan(&p.ID, &p.Title, &p.SKU, &p.Price)
+		`SELECT id, title, sku, price, version, updated_at FROM products WHERE id=$1 AND deleted_at IS NULL`, id,
+	).Scan(&p.ID, &p.Title, &p.SKU, &p.Price, &p.Version, &p.UpdatedAt)
 
 	if err == sql.ErrNoRows {
-		return nil, errors.New("not found")
+		return nil, domain.ErrProductNotFound
 	}
 	if err != nil {
 		return nil, err
@@ -83,15 +91,99 @@ func (r *PostgresRepository) Get(ctx context.Context, id string) (*domain.Produc
 }
 
 func (r *PostgresRepository) GetBatch(ctx context.Context, ids []string) ([]domain.Product, error) {
-	out := make([]domain.Product, 0, len(ids))
-	for _, id := range ids {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, title, sku, price, version, updated_at FROM products WHERE id = ANY($1) AND deleted_at IS NULL`, ids,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := make(map[string]domain.Product, len(ids))
+	for rows.Next() {
 		var p domain.Product
-		err := r.db.QueryRowContext(ctx,
-			`SELECT id, title, sku, price FROM products WHERE id=$1`, id,
-		).Scan(&p.ID, &p.Title, &p.SKU, &p.Price)
-		if err == nil {
+		if err := rows.Scan(&p.ID, &p.Title, &p.SKU, &p.Price, &p.Version, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		found[p.ID] = p
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	out := make([]domain.Product, 0, len(found))
+	for _, id := range ids {
+		if p, ok := found[id]; ok {
 			out = append(out, p)
 		}
 	}
 	return out, nil
 }
+
+var productSorts = map[string]page.Column{
+	"title":     {Name: "title", Type: "text"},
+	"price":     {Name: "price", Type: "bigint"},
+	"updatedAt": {Name: "updated_at", Type: "timestamp"},
+}
+
+func (r *PostgresRepository) List(ctx context.Context, f domain.ProductFilter, pr page.Request) (*page.Page[domain.Product], error) {
+	q, err := page.Parse(pr, productSorts, "title")
+	if err != nil {
+		return nil, err
+	}
+
+	where := []string{"deleted_at IS NULL"}
+	var args []any
+	if f.MinPrice != nil {
+		args = append(args, *f.MinPrice)
+		where = append(where, fmt.Sprintf("price >= $%d", len(args)))
+	}
+	if f.MaxPrice != nil {
+		args = append(args, *f.MaxPrice)
+		where = append(where, fmt.Sprintf("price <= $%d", len(args)))
+	}
+	if f.SKUPrefix != "" {
+		args = append(args, escapeLike(f.SKUPrefix)+"%")
+		where = append(where, fmt.Sprintf("sku LIKE $%d", len(args)))
+	}
+	if seek, seekArgs := q.Seek(len(args) + 1); seek != "" {
+		args = append(args, seekArgs...)
+		where = append(where, seek)
+	}
+
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, title, sku, price, version, updated_at FROM products WHERE `+strings.Join(where, " AND ")+q.OrderBy(),
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []domain.Product
+	for rows.Next() {
+		var p domain.Product
+		if err := rows.Scan(&p.ID, &p.Title, &p.SKU, &p.Price, &p.Version, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return page.Finish(q, out, func(p domain.Product) (string, string) {
+		switch q.Column.Name {
+		case "price":
+			return strconv.FormatInt(p.Price, 10), p.ID
+		case "updated_at":
+			return p.UpdatedAt.Format(time.RFC3339Nano), p.ID
+		}
+		return p.Title, p.ID
+	}), nil
+}
+
+// escapeLike makes s match literally in a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
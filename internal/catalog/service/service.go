@@ -2,14 +2,21 @@ package service
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
+	"slices"
 	"time"
 
 	"ecom/internal/catalog/domain"
 	"ecom/internal/common"
+	"ecom/internal/common/apperr"
+	"ecom/internal/common/outbox"
+	"ecom/internal/common/page"
 	"github.com/google/uuid"
 )
 
+// source identifies this service in the events it emits.
+const source = "catalog"
+
 type CatalogService struct {
 	repo domain.Repository
 }
@@ -28,21 +35,24 @@ func (s *CatalogService) CreateProduct(ctx context.Context, title, sku string, p
 		Title:     title,
 		SKU:       sku,
 		Price:     price,
+		Version:   1,
 		UpdatedAt: now,
 	}
+	if err := validate(p); err != nil {
+		return nil, err
+	}
 
 	evt := common.ProductUpserted{
 		ID:        id,
 		Title:     title,
 		SKU:       sku,
 		Price:     price,
+		Version:   1,
 		UpdatedAt: now,
 	}
-	payload, _ := json.Marshal(evt)
-
-	msg := &domain.OutboxMessage{
-		RoutingKey: "product.upserted",
-		Payload:    payload,
+	msg, err := outbox.NewEvent(source, common.EventProductUpserted, id, evt)
+	if err != nil {
+		return nil, err
 	}
 
 	if err := s.repo.SaveWithOutbox(ctx, p, msg); err != nil {
@@ -52,10 +62,135 @@ func (s *CatalogService) CreateProduct(ctx context.Context, title, sku string, p
 	return p, nil
 }
 
+// ProductChanges is a partial update; nil fields are left as they are.
+// If Version is set the update only applies to that version of the product.
+type ProductChanges struct {
+	Title   *string
+	SKU     *string
+	Price   *int64
+	Version *int64
+}
+
+func (s *CatalogService) UpdateProduct(ctx context.Context, id string, ch ProductChanges) (*domain.Product, error) {
+	p, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if ch.Version != nil && *ch.Version != p.Version {
+		return nil, domain.ErrProductVersionConflict
+	}
+	if ch.Title != nil {
+		p.Title = *ch.Title
+	}
+	if ch.SKU != nil {
+		p.SKU = *ch.SKU
+	}
+	if ch.Price != nil {
+		p.Price = *ch.Price
+	}
+	if err := validate(p); err != nil {
+		return nil, err
+	}
+	p.Version++
+	p.UpdatedAt = time.Now().UTC()
+
+	evt := common.ProductUpserted{
+		ID:        p.ID,
+		Title:     p.Title,
+		SKU:       p.SKU,
+		Price:     p.Price,
+		Version:   p.Version,
+		UpdatedAt: p.UpdatedAt,
+	}
+	msg, err := outbox.NewEvent(source, common.EventProductUpserted, p.ID, evt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.UpdateWithOutbox(ctx, p, msg); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// DeleteProduct soft-deletes a product and announces it with
+// product.deleted.
+func (s *CatalogService) DeleteProduct(ctx context.Context, id string) error {
+	p, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	p.Version++
+	p.UpdatedAt = now
+	p.DeletedAt = &now
+
+	evt := common.ProductDeleted{
+		ID:        p.ID,
+		Version:   p.Version,
+		DeletedAt: now,
+	}
+	msg, err := outbox.NewEvent(source, common.EventProductDeleted, p.ID, evt)
+	if err != nil {
+		return err
+	}
+
+	return s.repo.UpdateWithOutbox(ctx, p, msg)
+}
+
 func (s *CatalogService) GetProduct(ctx context.Context, id string) (*domain.Product, error) {
 	return s.repo.Get(ctx, id)
 }
 
-func (s *CatalogService) GetProductsBatch(ctx context.Context, ids []string) ([]domain.Product, error) {
-	return s.repo.GetBatch(ctx, ids)
+func (s *CatalogService) ListProducts(ctx context.Context, f domain.ProductFilter, r page.Request) (*page.Page[domain.Product], error) {
+	return s.repo.List(ctx, f, r)
+}
+
+// GetProductsBatch looks up to domain.MaxBatchSize products at once.
+// Repeated IDs are looked up once.
+func (s *CatalogService) GetProductsBatch(ctx context.Context, ids []string) (*domain.ProductBatch, error) {
+	ids = slices.Clone(ids)
+	seen := make(map[string]bool, len(ids))
+	ids = slices.DeleteFunc(ids, func(id string) bool {
+		dup := seen[id]
+		seen[id] = true
+		return dup
+	})
+	if len(ids) > domain.MaxBatchSize {
+		return nil, apperr.Validation("batch_too_large", fmt.Sprintf("at most %d ids per batch", domain.MaxBatchSize),
+			apperr.FieldError{Field: "ids", Message: fmt.Sprintf("must not have more than %d entries", domain.MaxBatchSize)})
+	}
+
+	products, err := s.repo.GetBatch(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &domain.ProductBatch{Products: products, Missing: []string{}}
+	for _, p := range products {
+		delete(seen, p.ID)
+	}
+	for _, id := range ids {
+		if seen[id] {
+			out.Missing = append(out.Missing, id)
+		}
+	}
+	return out, nil
+}
+
+func validate(p *domain.Product) error {
+	var fields []apperr.FieldError
+	if p.Title == "" {
+		fields = append(fields, apperr.FieldError{Field: "title", Message: "is required"})
+	}
+	if p.SKU == "" {
+		fields = append(fields, apperr.FieldError{Field: "sku", Message: "is required"})
+	}
+	if p.Price < 0 {
+		fields = append(fields, apperr.FieldError{Field: "price", Message: "must not be negative"})
+	}
+	if len(fields) > 0 {
+		return apperr.Validation("invalid_product", "product is invalid", fields...)
+	}
+	return nil
 }
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"ecom/internal/catalog/domain"
+	"ecom/internal/common/apperr"
+)
+
+// fakeRepo serves GetBatch from a map and records the IDs it was asked
+// for. Methods the tests don't reach panic on the nil embedded Repository.
+type fakeRepo struct {
+	domain.Repository
+
+	products map[string]domain.Product
+	lookups  [][]string
+}
+
+func (r *fakeRepo) GetBatch(ctx context.Context, ids []string) ([]domain.Product, error) {
+	r.lookups = append(r.lookups, ids)
+	var out []domain.Product
+	for _, id := range ids {
+		if p, ok := r.products[id]; ok {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+// seq returns n distinct IDs.
+func seq(n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("x%03d", i)
+	}
+	return ids
+}
+
+func TestGetProductsBatch(t *testing.T) {
+	tests := []struct {
+		name         string
+		ids          []string
+		wantLookup   []string
+		wantProducts []string
+		wantMissing  []string
+		wantErr      string
+	}{
+		{
+			name:         "all found",
+			ids:          []string{"b", "a"},
+			wantLookup:   []string{"b", "a"},
+			wantProducts: []string{"b", "a"},
+			wantMissing:  []string{},
+		},
+		{
+			name:         "duplicates looked up once",
+			ids:          []string{"a", "b", "a", "a"},
+			wantLookup:   []string{"a", "b"},
+			wantProducts: []string{"a", "b"},
+			wantMissing:  []string{},
+		},
+		{
+			name:         "missing in request order",
+			ids:          []string{"z", "a", "y", "z", "c"},
+			wantLookup:   []string{"z", "a", "y", "c"},
+			wantProducts: []string{"a", "c"},
+			wantMissing:  []string{"z", "y"},
+		},
+		{
+			name:        "nothing asked",
+			ids:         []string{},
+			wantLookup:  []string{},
+			wantMissing: []string{},
+		},
+		{
+			name:        "MaxBatchSize after dedupe",
+			ids:         append(seq(domain.MaxBatchSize), seq(10)...),
+			wantLookup:  seq(domain.MaxBatchSize),
+			wantMissing: seq(domain.MaxBatchSize),
+		},
+		{
+			name:    "over MaxBatchSize",
+			ids:     seq(domain.MaxBatchSize + 1),
+			wantErr: "batch_too_large",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeRepo{products: map[string]domain.Product{
+				"a": {ID: "a", Title: "Mug"},
+				"b": {ID: "b", Title: "Plate"},
+				"c": {ID: "c", Title: "Bowl"},
+			}}
+			asked := slices.Clone(tt.ids)
+
+			batch, err := NewCatalogService(repo).GetProductsBatch(context.Background(), tt.ids)
+			if !slices.Equal(tt.ids, asked) {
+				t.Errorf("ids changed to %v", tt.ids)
+			}
+
+			if tt.wantErr != "" {
+				var appErr *apperr.Error
+				if !errors.As(err, &appErr) || !errors.Is(err, apperr.ErrValidation) || appErr.Code != tt.wantErr {
+					t.Fatalf("err = %v, want a validation error %q", err, tt.wantErr)
+				}
+				if len(repo.lookups) != 0 {
+					t.Errorf("repository asked for %v, want no lookup", repo.lookups)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetProductsBatch: %v", err)
+			}
+
+			if len(repo.lookups) != 1 || !slices.Equal(repo.lookups[0], tt.wantLookup) {
+				t.Errorf("lookups = %v, want one of %v", repo.lookups, tt.wantLookup)
+			}
+			var got []string
+			for _, p := range batch.Products {
+				got = append(got, p.ID)
+			}
+			if !slices.Equal(got, tt.wantProducts) {
+				t.Errorf("products = %v, want %v", got, tt.wantProducts)
+			}
+			if batch.Missing == nil || !slices.Equal(batch.Missing, tt.wantMissing) {
+				t.Errorf("missing = %#v, want %#v", batch.Missing, tt.wantMissing)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+// Package admin builds the Fiber app for a service's operational routes.
+// It is served on its own listener, meant to be reachable only from inside
+// the deployment, and every request must carry the admin token.
+package admin
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"ecom/internal/common/apperr"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// New returns an app that rejects requests without
+// "Authorization: Bearer <token>".
+func New(token string) *fiber.App {
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.ErrorHandler})
+	app.Use(func(c *fiber.Ctx) error {
+		got, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			c.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+			return fiber.ErrUnauthorized
+		}
+		return c.Next()
+	})
+	return app
+}
@@ -0,0 +1,71 @@
+// Package apperr defines the error kinds shared by all services and maps
+// them to RFC 7807 problem responses.
+package apperr
+
+import (
+	"errors"
+	"strings"
+)
+
+// Kinds. Match them with errors.Is.
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrConflict    = errors.New("conflict")
+	ErrValidation  = errors.New("validation failed")
+	ErrUnavailable = errors.New("unavailable")
+)
+
+// Error is a domain error of one of the kinds above. Code is a stable,
+// machine-readable identifier clients can switch on; Detail is safe to
+// show to them.
+type Error struct {
+	Kind   error
+	Code   string
+	Detail string
+	Fields []FieldError
+	// Err is the underlying cause. It is logged, never sent to clients.
+	Err error
+}
+
+// FieldError points at one invalid part of a request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	msg := e.Detail
+	if msg == "" {
+		msg = e.Kind.Error()
+	}
+	if len(e.Fields) > 0 {
+		parts := make([]string, len(e.Fields))
+		for i, f := range e.Fields {
+			parts[i] = f.Field + ": " + f.Message
+		}
+		msg += " (" + strings.Join(parts, "; ") + ")"
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *Error) Is(target error) bool { return target == e.Kind }
+func (e *Error) Unwrap() error        { return e.Err }
+
+func NotFound(code, detail string) *Error {
+	return &Error{Kind: ErrNotFound, Code: code, Detail: detail}
+}
+
+func Conflict(code, detail string) *Error {
+	return &Error{Kind: ErrConflict, Code: code, Detail: detail}
+}
+
+func Validation(code, detail string, fields ...FieldError) *Error {
+	return &Error{Kind: ErrValidation, Code: code, Detail: detail, Fields: fields}
+}
+
+func Unavailable(code, detail string, cause error) *Error {
+	return &Error{Kind: ErrUnavailable, Code: code, Detail: detail, Err: cause}
+}
@@ -0,0 +1,97 @@
+package apperr
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const problemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 response body. Code and Errors are extensions.
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// ErrorHandler is a fiber.ErrorHandler that renders every error returned
+// by a handler as a problem. Errors that are neither an *Error nor a
+// *fiber.Error become a generic 500 and are only logged.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	p := toProblem(err)
+	if p.Status >= 500 {
+		log.Printf("%s %s: %v", c.Method(), c.Path(), err)
+	}
+	p.Instance = c.OriginalURL()
+
+	c.Status(p.Status)
+	c.Set(fiber.HeaderContentType, problemContentType)
+	return c.JSON(p, problemContentType)
+}
+
+func toProblem(err error) Problem {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return newProblem(statusOf(appErr.Kind), appErr.Code, appErr.Detail, appErr.Fields)
+	}
+
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		switch fiberErr.Code {
+		case fiber.StatusUnauthorized:
+			return newProblem(fiberErr.Code, "unauthorized", fiberErr.Message, nil)
+		case fiber.StatusNotFound:
+			return newProblem(fiberErr.Code, "route_not_found", fiberErr.Message, nil)
+		case fiber.StatusMethodNotAllowed:
+			return newProblem(fiberErr.Code, "method_not_allowed", fiberErr.Message, nil)
+		}
+		if fiberErr.Code < 500 {
+			return newProblem(fiberErr.Code, "bad_request", fiberErr.Message, nil)
+		}
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" { // unique_violation
+		return newProblem(http.StatusConflict, "conflict", "resource already exists", nil)
+	}
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) || errors.Is(err, context.DeadlineExceeded) {
+		return newProblem(http.StatusServiceUnavailable, "unavailable", "service temporarily unavailable", nil)
+	}
+
+	return newProblem(http.StatusInternalServerError, "internal", "internal error", nil)
+}
+
+func statusOf(kind error) int {
+	switch kind {
+	case ErrNotFound:
+		return http.StatusNotFound
+	case ErrConflict:
+		return http.StatusConflict
+	case ErrValidation:
+		return http.StatusBadRequest
+	case ErrUnavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func newProblem(status int, code, detail string, fields []FieldError) Problem {
+	return Problem{
+		Type:   "urn:ecom:problem:" + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+		Errors: fields,
+	}
+}
@@ -0,0 +1,78 @@
+// Package breaker implements a circuit breaker for calls to other
+// services.
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrOpen = errors.New("circuit breaker open")
+
+// Breaker opens after Threshold consecutive failures and rejects calls for
+// Cooldown. It then lets a single trial call through: success closes it
+// again, failure keeps it open for another Cooldown.
+//
+// Every call let through by Allow must be followed by Success, Failure or
+// Release.
+type Breaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time // zero while closed
+	trial    bool
+}
+
+func New() *Breaker {
+	return &Breaker{
+		Threshold: 5,
+		Cooldown:  30 * time.Second,
+	}
+}
+
+// Allow returns ErrOpen if the call should not be made.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openedAt.IsZero() {
+		return nil
+	}
+	if b.trial || time.Since(b.openedAt) < b.Cooldown {
+		return ErrOpen
+	}
+	b.trial = true
+	return nil
+}
+
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.openedAt = time.Time{}
+	b.trial = false
+}
+
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.trial || b.failures >= b.Threshold {
+		b.openedAt = time.Now()
+		b.trial = false
+	}
+}
+
+// Release ends a call that says nothing about the other service, such as
+// one its caller gave up on, without counting it either way.
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+}
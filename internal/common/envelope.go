@@ -0,0 +1,115 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SpecVersion is the CloudEvents version the envelope follows.
+const SpecVersion = "1.0"
+
+// ErrNewerVersion is returned when an event was written with a schema
+// version this build does not know yet, typically during a rolling deploy.
+// Such events are worth retrying rather than dead-lettering.
+var ErrNewerVersion = errors.New("event schema version is newer than supported")
+
+// Envelope wraps every event payload written to the outbox and published on
+// domain.events. Attribute names follow CloudEvents; DataVersion is the
+// schema version of Data.
+type Envelope struct {
+	SpecVersion string          `json:"specversion"`
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	Source      string          `json:"source"`
+	Subject     string          `json:"subject,omitempty"`
+	Time        time.Time       `json:"time"`
+	DataVersion int             `json:"dataversion"`
+	Data        json.RawMessage `json:"data"`
+}
+
+// NewEnvelope wraps data as the current schema version of eventType.
+// subject is the ID of the aggregate the event is about.
+func NewEnvelope(source, eventType, subject string, data any) (*Envelope, error) {
+	version, ok := currentVersions[eventType]
+	if !ok {
+		return nil, fmt.Errorf("unknown event type %q", eventType)
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{
+		SpecVersion: SpecVersion,
+		ID:          uuid.NewString(),
+		Type:        eventType,
+		Source:      source,
+		Subject:     subject,
+		Time:        time.Now().UTC(),
+		DataVersion: version,
+		Data:        raw,
+	}, nil
+}
+
+// ParseEnvelope reads an envelope from a message body. Bodies published
+// before envelopes existed are bare payloads; they are wrapped as version 1
+// of eventType.
+func ParseEnvelope(body []byte, eventType string) (*Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		return nil, err
+	}
+	if env.SpecVersion == "" {
+		return &Envelope{Type: eventType, DataVersion: 1, Data: body}, nil
+	}
+	if env.DataVersion == 0 {
+		env.DataVersion = 1
+	}
+	return &env, nil
+}
+
+// Upcaster rewrites the data of one schema version into the next one.
+type Upcaster func(data json.RawMessage) (json.RawMessage, error)
+
+// upcasters[eventType][v] turns version v into version v+1.
+var upcasters = map[string]map[int]Upcaster{}
+
+// RegisterUpcaster makes DecodeEvent able to lift eventType from version
+// from to from+1. It is meant to be called from init functions.
+func RegisterUpcaster(eventType string, from int, fn Upcaster) {
+	if upcasters[eventType] == nil {
+		upcasters[eventType] = map[int]Upcaster{}
+	}
+	upcasters[eventType][from] = fn
+}
+
+// DecodeEvent upcasts the envelope's data to the current schema version of
+// its type and unmarshals it into T.
+func DecodeEvent[T any](env *Envelope) (T, error) {
+	var out T
+	current, ok := currentVersions[env.Type]
+	if !ok {
+		return out, fmt.Errorf("unknown event type %q", env.Type)
+	}
+	if env.DataVersion > current {
+		return out, fmt.Errorf("%s v%d: %w", env.Type, env.DataVersion, ErrNewerVersion)
+	}
+
+	data := env.Data
+	for v := env.DataVersion; v < current; v++ {
+		up, ok := upcasters[env.Type][v]
+		if !ok {
+			return out, fmt.Errorf("%s: no upcaster from v%d", env.Type, v)
+		}
+		var err error
+		if data, err = up(data); err != nil {
+			return out, fmt.Errorf("%s: upcast v%d: %w", env.Type, v, err)
+		}
+	}
+
+	err := json.Unmarshal(data, &out)
+	return out, err
+}
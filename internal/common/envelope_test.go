@@ -0,0 +1,153 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseEnvelope(t *testing.T) {
+	bare := []byte(`{"id":"c1","name":"Ada","email":"ada@example.com"}`)
+	wrapped, err := json.Marshal(Envelope{
+		SpecVersion: SpecVersion,
+		ID:          "m1",
+		Type:        EventCustomerDeleted,
+		Subject:     "c1",
+		Data:        json.RawMessage(`{"id":"c1"}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		body        []byte
+		wantID      string
+		wantType    string
+		wantVersion int
+	}{
+		{name: "bare payload", body: bare, wantType: EventCustomerUpserted, wantVersion: 1},
+		{name: "envelope without dataversion", body: wrapped, wantID: "m1", wantType: EventCustomerDeleted, wantVersion: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the routing key only matters for bare payloads
+			env, err := ParseEnvelope(tt.body, EventCustomerUpserted)
+			if err != nil {
+				t.Fatalf("ParseEnvelope: %v", err)
+			}
+			if env.ID != tt.wantID || env.Type != tt.wantType || env.DataVersion != tt.wantVersion {
+				t.Errorf("envelope = {ID:%q Type:%q DataVersion:%d}, want {ID:%q Type:%q DataVersion:%d}",
+					env.ID, env.Type, env.DataVersion, tt.wantID, tt.wantType, tt.wantVersion)
+			}
+		})
+	}
+
+	if _, err := ParseEnvelope([]byte("not json"), EventCustomerUpserted); err == nil {
+		t.Error("ParseEnvelope accepted a body that is not JSON")
+	}
+}
+
+func TestDecodeEventCustomerUpserted(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		version int
+		data    string
+		want    CustomerUpserted
+	}{
+		{
+			name:    "v1 upcast to version 0",
+			version: 1,
+			data:    `{"id":"c1","name":"Ada","email":"ada@example.com","updatedAt":"2024-05-01T12:00:00Z"}`,
+			want:    CustomerUpserted{ID: "c1", Name: "Ada", Email: "ada@example.com", Version: 0, UpdatedAt: at},
+		},
+		{
+			name:    "v1 with a version field keeps it",
+			version: 1,
+			data:    `{"id":"c1","version":4}`,
+			want:    CustomerUpserted{ID: "c1", Version: 4},
+		},
+		{
+			name:    "v2 as is",
+			version: 2,
+			data:    `{"id":"c1","name":"Ada","email":"ada@example.com","version":7,"updatedAt":"2024-05-01T12:00:00Z"}`,
+			want:    CustomerUpserted{ID: "c1", Name: "Ada", Email: "ada@example.com", Version: 7, UpdatedAt: at},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := &Envelope{Type: EventCustomerUpserted, DataVersion: tt.version, Data: json.RawMessage(tt.data)}
+			got, err := DecodeEvent[CustomerUpserted](env)
+			if err != nil {
+				t.Fatalf("DecodeEvent: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("DecodeEvent = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeEventProductUpserted(t *testing.T) {
+	env := &Envelope{
+		Type:        EventProductUpserted,
+		DataVersion: 1,
+		Data:        json.RawMessage(`{"id":"p1","title":"Mug","sku":"MUG-1","price":1299}`),
+	}
+	got, err := DecodeEvent[ProductUpserted](env)
+	if err != nil {
+		t.Fatalf("DecodeEvent: %v", err)
+	}
+	want := ProductUpserted{ID: "p1", Title: "Mug", SKU: "MUG-1", Price: 1299, Version: 0}
+	if got != want {
+		t.Errorf("DecodeEvent = %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeEventErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   Envelope
+		newer bool
+	}{
+		{
+			name:  "newer than supported",
+			env:   Envelope{Type: EventCustomerUpserted, DataVersion: 3, Data: json.RawMessage(`{"id":"c1"}`)},
+			newer: true,
+		},
+		{
+			name:  "newer than a v1 type",
+			env:   Envelope{Type: EventProductDeleted, DataVersion: 2, Data: json.RawMessage(`{"id":"p1"}`)},
+			newer: true,
+		},
+		{
+			name: "unknown type",
+			env:  Envelope{Type: "customer.renamed", DataVersion: 1, Data: json.RawMessage(`{}`)},
+		},
+		{
+			name: "upcaster fails",
+			env:  Envelope{Type: EventCustomerUpserted, DataVersion: 1, Data: json.RawMessage(`[1,2]`)},
+		},
+		{
+			name: "data does not fit",
+			env:  Envelope{Type: EventCustomerDeleted, DataVersion: 1, Data: json.RawMessage(`{"id":42}`)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeEvent[CustomerUpserted](&tt.env)
+			if err == nil {
+				t.Fatal("DecodeEvent succeeded, want an error")
+			}
+			if got := errors.Is(err, ErrNewerVersion); got != tt.newer {
+				t.Errorf("errors.Is(%v, ErrNewerVersion) = %v, want %v", err, got, tt.newer)
+			}
+		})
+	}
+}
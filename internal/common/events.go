@@ -1,20 +1,124 @@
 package common
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 const ExchangeName = "domain.events"
 
+// Event types double as routing keys.
+const (
+	EventCustomerUpserted = "customer.upserted"
+	EventCustomerDeleted  = "customer.deleted"
+	EventProductUpserted  = "product.upserted"
+	EventProductDeleted   = "product.deleted"
+
+	EventOrderCreated       = "order.created"
+	EventOrderStatusChanged = "order.status_changed"
+	EventOrderCancelled     = "order.cancelled"
+)
+
+// currentVersions holds the schema version producers write for each event
+// type. Older versions are lifted by the upcasters registered in init.
+var currentVersions = map[string]int{
+	EventCustomerUpserted: 2,
+	EventCustomerDeleted:  1,
+	EventProductUpserted:  2,
+	EventProductDeleted:   1,
+
+	EventOrderCreated:       1,
+	EventOrderStatusChanged: 1,
+	EventOrderCancelled:     1,
+}
+
+// v2 of CustomerUpserted
 type CustomerUpserted struct {
 	ID        string    `json:"id"`
 	Name      string    `json:"name"`
 	Email     string    `json:"email"`
+	Version   int64     `json:"version"` // starts at 1, bumped on every change
 	UpdatedAt time.Time `json:"updatedAt"`
 }
 
+// v1 of CustomerDeleted. Consumers must drop the personal data they hold
+// for the customer.
+type CustomerDeleted struct {
+	ID        string    `json:"id"`
+	Version   int64     `json:"version"`
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+// v2 of ProductUpserted
 type ProductUpserted struct {
 	ID        string    `json:"id"`
 	Title     string    `json:"title"`
 	SKU       string    `json:"sku"`
-	Price     int64     `json:"price"` // cents
+	Price     int64     `json:"price"`   // cents
+	Version   int64     `json:"version"` // starts at 1, bumped on every change
 	UpdatedAt time.Time `json:"updatedAt"`
 }
+
+// v1 of ProductDeleted. Products are soft-deleted, so Version carries on
+// from the last ProductUpserted and DeletedAt plays the part of UpdatedAt.
+type ProductDeleted struct {
+	ID        string    `json:"id"`
+	Version   int64     `json:"version"`
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+// v1 of OrderCreated
+type OrderCreated struct {
+	ID         string      `json:"id"`
+	CustomerID string      `json:"customerId"`
+	Status     string      `json:"status"`
+	Items      []OrderLine `json:"items"`
+	Total      int64       `json:"total"`   // cents
+	Version    int64       `json:"version"` // starts at 1, bumped on every status change
+	CreatedAt  time.Time   `json:"createdAt"`
+}
+
+type OrderLine struct {
+	ProductID string `json:"productId"`
+	Quantity  int    `json:"quantity"`
+	UnitPrice int64  `json:"unitPrice"` // cents
+}
+
+// v1 of OrderStatusChanged, sent for every transition including
+// cancellation.
+type OrderStatusChanged struct {
+	ID        string    `json:"id"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Reason    string    `json:"reason,omitempty"`
+	Version   int64     `json:"version"`
+	ChangedAt time.Time `json:"changedAt"`
+}
+
+// v1 of OrderCancelled, sent alongside OrderStatusChanged for consumers
+// that only care about cancellations.
+type OrderCancelled struct {
+	ID          string    `json:"id"`
+	CustomerID  string    `json:"customerId"`
+	Reason      string    `json:"reason,omitempty"`
+	Version     int64     `json:"version"`
+	CancelledAt time.Time `json:"cancelledAt"`
+}
+
+func init() {
+	// v1 payloads predate aggregate versions. They come through as version 0,
+	// which never wins over a cache row written from a versioned event.
+	RegisterUpcaster(EventCustomerUpserted, 1, addVersionField)
+	RegisterUpcaster(EventProductUpserted, 1, addVersionField)
+}
+
+func addVersionField(data json.RawMessage) (json.RawMessage, error) {
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	if _, ok := m["version"]; !ok {
+		m["version"] = 0
+	}
+	return json.Marshal(m)
+}
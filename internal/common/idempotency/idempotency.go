@@ -0,0 +1,151 @@
+// Package idempotency makes POST requests safe to retry. A client sends an
+// Idempotency-Key header; the first request with a key runs and its
+// response is stored, later ones with the same key and body get that
+// response replayed instead of running again.
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"ecom/internal/common/apperr"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	HeaderKey      = "Idempotency-Key"
+	HeaderReplayed = "Idempotent-Replayed"
+
+	maxKeyLength = 255
+)
+
+var (
+	ErrKeyReused  = apperr.Conflict("idempotency_key_reused", "Idempotency-Key was already used for a different request")
+	ErrInProgress = apperr.Conflict("idempotency_key_in_progress", "a request with this Idempotency-Key is still being processed")
+)
+
+// Record is a stored key. Status is 0 while the first request is running.
+type Record struct {
+	Key         string
+	RequestHash string
+	Status      int
+	ContentType string
+	Response    []byte
+}
+
+type Store interface {
+	// Claim stores key for a new request unless it is already taken, in
+	// which case it returns the existing record and false. Records older
+	// than ttl, and unfinished ones older than lockTimeout, are taken over.
+	Claim(ctx context.Context, key, requestHash string, ttl, lockTimeout time.Duration) (*Record, bool, error)
+	Complete(ctx context.Context, key string, status int, contentType string, response []byte) error
+	// Release forgets a claimed key so the request can be retried.
+	Release(ctx context.Context, key string) error
+	// Expire deletes the keys created before before.
+	Expire(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Middleware is Fiber middleware applying Idempotency-Key to POST
+// requests. Keys are kept for TTL and deleted by Run every
+// ExpireInterval. A request that dies without finishing holds its key for
+// LockTimeout.
+type Middleware struct {
+	store Store
+
+	TTL            time.Duration
+	LockTimeout    time.Duration
+	ExpireInterval time.Duration
+}
+
+func New(store Store) *Middleware {
+	return &Middleware{
+		store:          store,
+		TTL:            24 * time.Hour,
+		LockTimeout:    time.Minute,
+		ExpireInterval: time.Hour,
+	}
+}
+
+// Run deletes expired keys every ExpireInterval until ctx is done.
+func (m *Middleware) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.ExpireInterval)
+	defer ticker.Stop()
+	for {
+		n, err := m.store.Expire(ctx, time.Now().UTC().Add(-m.TTL))
+		if err != nil && ctx.Err() == nil {
+			log.Printf("idempotency: expire keys: %v", err)
+		}
+		if n > 0 {
+			log.Printf("idempotency: expired %d keys", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Handle stores the responses of requests that went through. Requests
+// ending in an error or a 5xx release their key, so a retry runs again.
+func (m *Middleware) Handle(c *fiber.Ctx) error {
+	key := c.Get(HeaderKey)
+	if c.Method() != fiber.MethodPost || key == "" {
+		return c.Next()
+	}
+	if len(key) > maxKeyLength {
+		return apperr.Validation("invalid_idempotency_key", "Idempotency-Key is too long",
+			apperr.FieldError{Field: HeaderKey, Message: "must be at most 255 characters"})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 2*time.Second)
+	defer cancel()
+
+	hash := requestHash(c)
+	rec, claimed, err := m.store.Claim(ctx, key, hash, m.TTL, m.LockTimeout)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		switch {
+		case rec.RequestHash != hash:
+			return ErrKeyReused
+		case rec.Status == 0:
+			return ErrInProgress
+		}
+		c.Set(HeaderReplayed, "true")
+		c.Set(fiber.HeaderContentType, rec.ContentType)
+		return c.Status(rec.Status).Send(rec.Response)
+	}
+
+	// the handler may run out the request's own deadline, so settle the
+	// key on a fresh one
+	settle, cancel := context.WithTimeout(context.WithoutCancel(ctx), 2*time.Second)
+	defer cancel()
+
+	if err := c.Next(); err != nil {
+		if rerr := m.store.Release(settle, key); rerr != nil {
+			return rerr
+		}
+		return err
+	}
+
+	resp := c.Response()
+	if resp.StatusCode() >= 500 {
+		return m.store.Release(settle, key)
+	}
+	return m.store.Complete(settle, key, resp.StatusCode(), string(resp.Header.ContentType()), resp.Body())
+}
+
+// requestHash identifies a request by method, path and body, so a key
+// reused on another endpoint counts as a different request.
+func requestHash(c *fiber.Ctx) string {
+	h := sha256.New()
+	h.Write([]byte(c.Method() + " " + c.Path() + "\n"))
+	h.Write(c.Body())
+	return hex.EncodeToString(h.Sum(nil))
+}
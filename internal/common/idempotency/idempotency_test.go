@@ -0,0 +1,302 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ecom/internal/common/apperr"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// memStore is a Store kept in a map. Keys never go stale.
+type memStore struct {
+	mu   sync.Mutex
+	recs map[string]Record
+}
+
+func newMemStore() *memStore {
+	return &memStore{recs: make(map[string]Record)}
+}
+
+func (s *memStore) Claim(ctx context.Context, key, requestHash string, ttl, lockTimeout time.Duration) (*Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rec, ok := s.recs[key]; ok {
+		return &rec, false, nil
+	}
+	s.recs[key] = Record{Key: key, RequestHash: requestHash}
+	return nil, true, nil
+}
+
+func (s *memStore) Complete(ctx context.Context, key string, status int, contentType string, response []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec := s.recs[key]
+	rec.Status, rec.ContentType, rec.Response = status, contentType, response
+	s.recs[key] = rec
+	return nil
+}
+
+func (s *memStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.recs[key].Status == 0 {
+		delete(s.recs, key)
+	}
+	return nil
+}
+
+func (s *memStore) Expire(ctx context.Context, before time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (s *memStore) has(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.recs[key]
+	return ok
+}
+
+// newApp serves handler on POST /orders behind the middleware.
+func newApp(store Store, handler fiber.Handler) *fiber.App {
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.ErrorHandler})
+	app.Use(New(store).Handle)
+	app.Post("/orders", handler)
+	app.Get("/orders", handler)
+	return app
+}
+
+func send(t *testing.T, app *fiber.App, method, path, key, body string) (*http.Response, string) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	if key != "" {
+		req.Header.Set(HeaderKey, key)
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(b)
+}
+
+func problemCode(t *testing.T, body string) string {
+	t.Helper()
+	var p apperr.Problem
+	if err := json.Unmarshal([]byte(body), &p); err != nil {
+		t.Fatalf("body %q is not a problem: %v", body, err)
+	}
+	return p.Code
+}
+
+func TestHandleReplaysCompletedKey(t *testing.T) {
+	var calls int
+	app := newApp(newMemStore(), func(c *fiber.Ctx) error {
+		calls++
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{"call": calls})
+	})
+
+	first, firstBody := send(t, app, fiber.MethodPost, "/orders", "k1", `{"qty":1}`)
+	second, secondBody := send(t, app, fiber.MethodPost, "/orders", "k1", `{"qty":1}`)
+
+	if calls != 1 {
+		t.Errorf("handler ran %d times, want 1", calls)
+	}
+	if first.StatusCode != fiber.StatusCreated || second.StatusCode != fiber.StatusCreated {
+		t.Errorf("statuses = %d, %d, want both %d", first.StatusCode, second.StatusCode, fiber.StatusCreated)
+	}
+	if secondBody != firstBody {
+		t.Errorf("replayed body = %s, want %s", secondBody, firstBody)
+	}
+	if got := second.Header.Get(fiber.HeaderContentType); got != first.Header.Get(fiber.HeaderContentType) {
+		t.Errorf("replayed content type = %q, want %q", got, first.Header.Get(fiber.HeaderContentType))
+	}
+	if first.Header.Get(HeaderReplayed) != "" || second.Header.Get(HeaderReplayed) != "true" {
+		t.Errorf("%s = %q, %q, want \"\", \"true\"",
+			HeaderReplayed, first.Header.Get(HeaderReplayed), second.Header.Get(HeaderReplayed))
+	}
+}
+
+func TestHandleRejectsReusedKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		path, body string
+	}{
+		{name: "different body", path: "/orders", body: `{"qty":2}`},
+		{name: "different path", path: "/orders/", body: `{"qty":1}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			app := fiber.New(fiber.Config{ErrorHandler: apperr.ErrorHandler})
+			app.Use(New(newMemStore()).Handle)
+			app.Post("/*", func(c *fiber.Ctx) error {
+				calls++
+				return c.SendStatus(fiber.StatusCreated)
+			})
+
+			send(t, app, fiber.MethodPost, "/orders", "k1", `{"qty":1}`)
+			resp, body := send(t, app, fiber.MethodPost, tt.path, "k1", tt.body)
+
+			if resp.StatusCode != fiber.StatusConflict || problemCode(t, body) != "idempotency_key_reused" {
+				t.Errorf("response = %d %s, want 409 idempotency_key_reused", resp.StatusCode, body)
+			}
+			if calls != 1 {
+				t.Errorf("handler ran %d times, want 1", calls)
+			}
+		})
+	}
+}
+
+func TestHandleRejectsInFlightKey(t *testing.T) {
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	app := newApp(newMemStore(), func(c *fiber.Ctx) error {
+		close(started)
+		<-finish
+		return c.SendStatus(fiber.StatusCreated)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req := httptest.NewRequest(fiber.MethodPost, "/orders", strings.NewReader(`{}`))
+		req.Header.Set(HeaderKey, "k1")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode != fiber.StatusCreated {
+			t.Errorf("first request status = %d, want %d", resp.StatusCode, fiber.StatusCreated)
+		}
+	}()
+	<-started
+
+	resp, body := send(t, app, fiber.MethodPost, "/orders", "k1", `{}`)
+	close(finish)
+	<-done
+
+	if resp.StatusCode != fiber.StatusConflict || problemCode(t, body) != "idempotency_key_in_progress" {
+		t.Errorf("response = %d %s, want 409 idempotency_key_in_progress", resp.StatusCode, body)
+	}
+}
+
+func TestHandleReleasesFailedRequests(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler fiber.Handler
+		want    int
+	}{
+		{
+			name:    "handler error",
+			handler: func(c *fiber.Ctx) error { return apperr.Unavailable("down", "try again", nil) },
+			want:    fiber.StatusServiceUnavailable,
+		},
+		{
+			name:    "validation error",
+			handler: func(c *fiber.Ctx) error { return apperr.Validation("bad", "bad input") },
+			want:    fiber.StatusBadRequest,
+		},
+		{
+			name:    "5xx response",
+			handler: func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusBadGateway) },
+			want:    fiber.StatusBadGateway,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemStore()
+			var calls int
+			app := newApp(store, func(c *fiber.Ctx) error {
+				calls++
+				return tt.handler(c)
+			})
+
+			for range 2 {
+				if resp, body := send(t, app, fiber.MethodPost, "/orders", "k1", `{}`); resp.StatusCode != tt.want {
+					t.Fatalf("status = %d %s, want %d", resp.StatusCode, body, tt.want)
+				}
+			}
+			if calls != 2 {
+				t.Errorf("handler ran %d times, want 2: the key was not released", calls)
+			}
+			if store.has("k1") {
+				t.Error("key is still stored after the request failed")
+			}
+		})
+	}
+}
+
+func TestHandleSkips(t *testing.T) {
+	tests := []struct {
+		name, method, key string
+		wantStatus        int
+	}{
+		{name: "no key", method: fiber.MethodPost, wantStatus: fiber.StatusOK},
+		{name: "not a POST", method: fiber.MethodGet, key: "k1", wantStatus: fiber.StatusOK},
+		{name: "key too long", method: fiber.MethodPost, key: strings.Repeat("k", maxKeyLength+1), wantStatus: fiber.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemStore()
+			app := newApp(store, func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+
+			if resp, body := send(t, app, tt.method, "/orders", tt.key, `{}`); resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d %s, want %d", resp.StatusCode, body, tt.wantStatus)
+			}
+			if len(store.recs) != 0 {
+				t.Errorf("stored %d keys, want none", len(store.recs))
+			}
+		})
+	}
+}
+
+func TestRequestHash(t *testing.T) {
+	var hashes []string
+	app := fiber.New()
+	app.All("/*", func(c *fiber.Ctx) error {
+		hashes = append(hashes, requestHash(c))
+		return nil
+	})
+	hash := func(method, path, body string) string {
+		if _, err := app.Test(httptest.NewRequest(method, path, strings.NewReader(body)), -1); err != nil {
+			t.Fatal(err)
+		}
+		return hashes[len(hashes)-1]
+	}
+
+	base := hash(fiber.MethodPost, "/orders", `{"qty":1}`)
+	if got := hash(fiber.MethodPost, "/orders", `{"qty":1}`); got != base {
+		t.Error("the same request hashed differently")
+	}
+	tests := []struct {
+		name, method, path, body string
+	}{
+		{"body", fiber.MethodPost, "/orders", `{"qty":2}`},
+		{"path", fiber.MethodPost, "/carts", `{"qty":1}`},
+		{"method", fiber.MethodPut, "/orders", `{"qty":1}`},
+	}
+	for _, tt := range tests {
+		if got := hash(tt.method, tt.path, tt.body); got == base {
+			t.Errorf("changing the %s left the hash as it was", tt.name)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// PostgresStore implements Store on top of a service's idempotency_keys
+// table.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) Claim(ctx context.Context, key, requestHash string, ttl, lockTimeout time.Duration) (*Record, bool, error) {
+	var claimed bool
+	err := s.db.QueryRowContext(ctx, `
+INSERT INTO idempotency_keys(key, request_hash) VALUES ($1, $2)
+ON CONFLICT (key) DO UPDATE
+SET request_hash = EXCLUDED.request_hash,
+    status = NULL,
+    content_type = NULL,
+    response = NULL,
+    created_at = NOW()
+WHERE idempotency_keys.created_at < NOW() - make_interval(secs => $3)
+   OR (idempotency_keys.status IS NULL AND idempotency_keys.created_at < NOW() - make_interval(secs => $4))
+RETURNING true
+`, key, requestHash, ttl.Seconds(), lockTimeout.Seconds()).Scan(&claimed)
+	if err == nil {
+		return nil, true, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, false, err
+	}
+
+	rec := Record{Key: key}
+	var (
+		status      sql.NullInt64
+		contentType sql.NullString
+	)
+	err = s.db.QueryRowContext(ctx,
+		`SELECT request_hash, status, content_type, response FROM idempotency_keys WHERE key = $1`, key,
+	).Scan(&rec.RequestHash, &status, &contentType, &rec.Response)
+	if err == sql.ErrNoRows {
+		// released between the two statements; let the client retry
+		return nil, false, ErrInProgress
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	rec.Status = int(status.Int64)
+	rec.ContentType = contentType.String
+	return &rec, false, nil
+}
+
+func (s *PostgresStore) Complete(ctx context.Context, key string, status int, contentType string, response []byte) error {
+	_, err := s.db.ExecContext(ctx, `
+UPDATE idempotency_keys
+SET status = $2, content_type = $3, response = $4, completed_at = NOW()
+WHERE key = $1
+`, key, status, contentType, response)
+	return err
+}
+
+func (s *PostgresStore) Release(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE key = $1 AND status IS NULL`, key)
+	return err
+}
+
+func (s *PostgresStore) Expire(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE created_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,72 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = "usage: migrate up | down [steps] | status"
+
+// Command runs the migrate subcommand of a service binary:
+//
+//	migrate up            apply all pending migrations
+//	migrate down [steps]  revert the last steps migrations (default 1)
+//	migrate status        list migrations and whether they are applied
+func (m *Migrator) Command(ctx context.Context, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	switch args[0] {
+	case "up":
+		if err := m.Up(ctx); err != nil {
+			return err
+		}
+		fmt.Fprintln(w, "migrations applied")
+		return nil
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid steps %q", args[1])
+			}
+			steps = n
+		}
+		if err := m.Down(ctx, steps); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "reverted up to %d migration(s)\n", steps)
+		return nil
+
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED\tNOTE")
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			note := ""
+			switch {
+			case s.Modified:
+				note = "modified since applied"
+			case s.Missing:
+				note = "not in this build"
+			}
+			fmt.Fprintf(tw, "%04d\t%s\t%s\t%s\n", s.Version, s.Name, applied, note)
+		}
+		return tw.Flush()
+	}
+	return errors.New(usage)
+}
@@ -0,0 +1,172 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"ecom/internal/common/apperr"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+// AdminStore is what the admin API needs from a store.
+type AdminStore interface {
+	ListMessages(ctx context.Context, f Filter) ([]Message, error)
+	GetMessage(ctx context.Context, id int64) (*Message, error)
+	RequeueMessages(ctx context.Context, ids []int64) (int64, error)
+}
+
+// AdminHandler exposes the outbox for inspection and replay.
+type AdminHandler struct {
+	store AdminStore
+}
+
+func NewAdminHandler(store AdminStore) *AdminHandler {
+	return &AdminHandler{store: store}
+}
+
+func (h *AdminHandler) RegisterRoutes(app fiber.Router) {
+	app.Get("/admin/outbox", h.List)
+	app.Post("/admin/outbox/requeue", h.Requeue)
+	app.Get("/admin/outbox/:id", h.Get)
+	app.Post("/admin/outbox/:id/requeue", h.RequeueOne)
+}
+
+type messageView struct {
+	ID            int64           `json:"id"`
+	MessageID     string          `json:"messageId"`
+	RoutingKey    string          `json:"routingKey"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	LastError     string          `json:"lastError,omitempty"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	NextAttemptAt *time.Time      `json:"nextAttemptAt,omitempty"`
+	ProcessedAt   *time.Time      `json:"processedAt,omitempty"`
+	QuarantinedAt *time.Time      `json:"quarantinedAt,omitempty"`
+	Payload       json.RawMessage `json:"payload,omitempty"`
+}
+
+func newMessageView(m *Message, withPayload bool) messageView {
+	v := messageView{
+		ID:            m.ID,
+		MessageID:     m.MessageID,
+		RoutingKey:    m.RoutingKey,
+		Status:        m.Status(),
+		Attempts:      m.Attempts,
+		LastError:     m.LastError,
+		CreatedAt:     m.CreatedAt,
+		ProcessedAt:   m.ProcessedAt,
+		QuarantinedAt: m.QuarantinedAt,
+	}
+	if v.Status == StatusPending {
+		v.NextAttemptAt = &m.NextAttemptAt
+	}
+	if withPayload {
+		v.Payload = m.Payload
+	}
+	return v
+}
+
+// List accepts status, routingKey, from and to (RFC 3339), beforeId and
+// limit query parameters.
+func (h *AdminHandler) List(c *fiber.Ctx) error {
+	f := Filter{
+		Status:     c.Query("status"),
+		RoutingKey: c.Query("routingKey"),
+		BeforeID:   int64(c.QueryInt("beforeId")),
+		Limit:      c.QueryInt("limit", defaultListLimit),
+	}
+	switch f.Status {
+	case "", StatusPending, StatusProcessed, StatusQuarantined:
+	default:
+		return apperr.Validation("invalid_query", "invalid filter", apperr.FieldError{Field: "status", Message: "must be pending, processed or quarantined"})
+	}
+	if f.Limit <= 0 || f.Limit > maxListLimit {
+		f.Limit = defaultListLimit
+	}
+	var err error
+	if f.From, err = parseTime(c.Query("from")); err != nil {
+		return apperr.Validation("invalid_query", "invalid filter", apperr.FieldError{Field: "from", Message: "must be an RFC 3339 timestamp"})
+	}
+	if f.To, err = parseTime(c.Query("to")); err != nil {
+		return apperr.Validation("invalid_query", "invalid filter", apperr.FieldError{Field: "to", Message: "must be an RFC 3339 timestamp"})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 2*time.Second)
+	defer cancel()
+
+	msgs, err := h.store.ListMessages(ctx, f)
+	if err != nil {
+		return err
+	}
+
+	out := make([]messageView, len(msgs))
+	for i := range msgs {
+		out[i] = newMessageView(&msgs[i], false)
+	}
+	return c.JSON(out)
+}
+
+func (h *AdminHandler) Get(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return apperr.Validation("invalid_id", "id must be an integer")
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 2*time.Second)
+	defer cancel()
+
+	m, err := h.store.GetMessage(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(newMessageView(m, true))
+}
+
+func (h *AdminHandler) Requeue(c *fiber.Ctx) error {
+	var in struct {
+		IDs []int64 `json:"ids"`
+	}
+	if err := c.BodyParser(&in); err != nil {
+		return apperr.Validation("invalid_body", "request body is not valid JSON")
+	}
+	if len(in.IDs) == 0 {
+		return apperr.Validation("invalid_body", "ids required", apperr.FieldError{Field: "ids", Message: "is required"})
+	}
+	return h.requeue(c, in.IDs)
+}
+
+func (h *AdminHandler) RequeueOne(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return apperr.Validation("invalid_id", "id must be an integer")
+	}
+	return h.requeue(c, []int64{id})
+}
+
+func (h *AdminHandler) requeue(c *fiber.Ctx, ids []int64) error {
+	ctx, cancel := context.WithTimeout(c.Context(), 2*time.Second)
+	defer cancel()
+
+	n, err := h.store.RequeueMessages(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(map[string]any{"requeued": n})
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
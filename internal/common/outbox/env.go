@@ -0,0 +1,47 @@
+package outbox
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"ecom/internal/common"
+)
+
+// RelayFromEnv returns a Relay tuned by OUTBOX_MAX_ATTEMPTS,
+// OUTBOX_BASE_BACKOFF and OUTBOX_MAX_BACKOFF where they are set.
+func RelayFromEnv(store Store, bus *common.Bus) (*Relay, error) {
+	r := NewRelay(store, bus)
+	var err error
+	if v := os.Getenv("OUTBOX_MAX_ATTEMPTS"); v != "" {
+		if r.MaxAttempts, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid OUTBOX_MAX_ATTEMPTS: %w", err)
+		}
+	}
+	if v := os.Getenv("OUTBOX_BASE_BACKOFF"); v != "" {
+		if r.BaseBackoff, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid OUTBOX_BASE_BACKOFF: %w", err)
+		}
+	}
+	if v := os.Getenv("OUTBOX_MAX_BACKOFF"); v != "" {
+		if r.MaxBackoff, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid OUTBOX_MAX_BACKOFF: %w", err)
+		}
+	}
+	return r, nil
+}
+
+// PrunerFromEnv returns a Pruner tuned by OUTBOX_RETENTION and
+// OUTBOX_ARCHIVE where they are set.
+func PrunerFromEnv(store PruneStore) (*Pruner, error) {
+	p := NewPruner(store)
+	if v := os.Getenv("OUTBOX_RETENTION"); v != "" {
+		var err error
+		if p.Retention, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid OUTBOX_RETENTION: %w", err)
+		}
+	}
+	p.Archive = os.Getenv("OUTBOX_ARCHIVE") == "true"
+	return p, nil
+}
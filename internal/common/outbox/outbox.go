@@ -0,0 +1,103 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"ecom/internal/common"
+	"ecom/internal/common/apperr"
+)
+
+var ErrMessageNotFound = apperr.NotFound("outbox_message_not_found", "outbox message not found")
+
+type Message struct {
+	ID int64
+	// MessageID travels with the published message so consumers can
+	// recognise redeliveries.
+	MessageID  string
+	RoutingKey string
+	Payload    []byte
+	CreatedAt  time.Time
+	Attempts   int
+	LastError  string
+
+	// Only filled in by the admin queries.
+	NextAttemptAt time.Time
+	ProcessedAt   *time.Time
+	QuarantinedAt *time.Time
+}
+
+// NewEvent wraps data in an event envelope and returns it as an outbox
+// message whose MessageID is the envelope ID.
+func NewEvent(source, eventType, subject string, data any) (*Message, error) {
+	env, err := common.NewEnvelope(source, eventType, subject, data)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{
+		MessageID:  env.ID,
+		RoutingKey: env.Type,
+		Payload:    payload,
+	}, nil
+}
+
+const (
+	StatusPending     = "pending"
+	StatusProcessed   = "processed"
+	StatusQuarantined = "quarantined"
+)
+
+func (m *Message) Status() string {
+	switch {
+	case m.ProcessedAt != nil:
+		return StatusProcessed
+	case m.QuarantinedAt != nil:
+		return StatusQuarantined
+	default:
+		return StatusPending
+	}
+}
+
+// Filter narrows down an admin listing. Zero values match everything;
+// From and To bound created_at. Results come newest first and BeforeID
+// pages through them.
+type Filter struct {
+	Status     string
+	RoutingKey string
+	From       time.Time
+	To         time.Time
+	BeforeID   int64
+	Limit      int
+}
+
+// Result is the relay's verdict on one claimed message. A nil Err marks the
+// message processed; otherwise the failure is recorded and the message is
+// either scheduled again at RetryAt or quarantined for good. With Release
+// set the message is left as it was, without using up an attempt.
+type Result struct {
+	ID         int64
+	Err        error
+	RetryAt    time.Time
+	Quarantine bool
+	Release    bool
+}
+
+// Store is implemented by any repository that keeps an outbox table.
+type Store interface {
+	// ClaimMessages locks up to limit messages that are due for delivery so
+	// concurrent relays skip them, hands them to publish and records the
+	// returned results before releasing the locks.
+	ClaimMessages(ctx context.Context, limit int, publish func(msgs []Message) []Result) error
+}
+
+// Listener is optionally implemented by a Store that can push a wake-up
+// signal when new messages are committed. The relay still polls on its
+// interval as a fallback.
+type Listener interface {
+	Listen(ctx context.Context) <-chan struct{}
+}
@@ -0,0 +1,375 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// Channel is the NOTIFY channel Enqueue signals on commit.
+const Channel = "outbox_messages"
+
+// PostgresStore implements Store on top of the outbox table. Repositories
+// embed it to expose the outbox methods next to their own.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// Execer runs statements; *sql.Tx is the usual one.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// Enqueue writes m to the outbox inside the caller's transaction, assigning
+// it a MessageID if it has none. tx must run its statements in that
+// transaction. Postgres holds the NOTIFY back until the transaction
+// commits, so listeners never wake up for a row they cannot see yet.
+func Enqueue(ctx context.Context, tx Execer, m *Message) error {
+	if m.MessageID == "" {
+		m.MessageID = uuid.NewString()
+	}
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO outbox(message_id, routing_key, payload) VALUES ($1,$2,$3)`,
+		m.MessageID, m.RoutingKey, m.Payload,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `SELECT pg_notify($1, '')`, Channel)
+	return err
+}
+
+func (s *PostgresStore) ClaimMessages(ctx context.Context, limit int, publish func(msgs []Message) []Result) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+SELECT id, message_id, routing_key, payload, created_at, attempts, COALESCE(last_error, '')
+FROM outbox
+WHERE processed_at IS NULL
+  AND quarantined_at IS NULL
+  AND next_attempt_at <= NOW()
+ORDER BY id
+LIMIT $1
+FOR UPDATE SKIP LOCKED
+`, limit)
+	if err != nil {
+		return err
+	}
+
+	var msgs []Message
+	for rows.Next() {
+		var m Message
+		if err := rows.Scan(&m.ID, &m.MessageID, &m.RoutingKey, &m.Payload, &m.CreatedAt, &m.Attempts, &m.LastError); err != nil {
+			rows.Close()
+			return err
+		}
+		msgs = append(msgs, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	var done []int64
+	for _, res := range publish(msgs) {
+		if res.Err == nil {
+			done = append(done, res.ID)
+			continue
+		}
+		if res.Release {
+			continue
+		}
+		_, err = tx.ExecContext(ctx, `
+UPDATE outbox
+SET attempts = attempts + 1,
+    last_error = $2,
+    next_attempt_at = CASE WHEN $4 THEN next_attempt_at ELSE $3 END,
+    quarantined_at = CASE WHEN $4 THEN NOW() END
+WHERE id = $1
+`, res.ID, res.Err.Error(), res.RetryAt.UTC(), res.Quarantine)
+		if err != nil {
+			return err
+		}
+	}
+	if len(done) > 0 {
+		_, err = tx.ExecContext(ctx,
+			`UPDATE outbox SET processed_at = NOW() WHERE id = ANY($1)`, done,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+const adminColumns = `id, message_id, routing_key, payload, created_at, attempts, COALESCE(last_error, ''),
+       next_attempt_at, processed_at, quarantined_at`
+
+func scanAdmin(row interface{ Scan(...any) error }) (Message, error) {
+	var m Message
+	err := row.Scan(&m.ID, &m.MessageID, &m.RoutingKey, &m.Payload, &m.CreatedAt, &m.Attempts, &m.LastError,
+		&m.NextAttemptAt, &m.ProcessedAt, &m.QuarantinedAt)
+	return m, err
+}
+
+func (s *PostgresStore) ListMessages(ctx context.Context, f Filter) ([]Message, error) {
+	var (
+		where []string
+		args  []any
+	)
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	switch f.Status {
+	case "":
+	case StatusPending:
+		where = append(where, "processed_at IS NULL AND quarantined_at IS NULL")
+	case StatusProcessed:
+		where = append(where, "processed_at IS NOT NULL")
+	case StatusQuarantined:
+		where = append(where, "quarantined_at IS NOT NULL")
+	default:
+		return nil, fmt.Errorf("unknown status %q", f.Status)
+	}
+	if f.RoutingKey != "" {
+		where = append(where, "routing_key = "+arg(f.RoutingKey))
+	}
+	if !f.From.IsZero() {
+		where = append(where, "created_at >= "+arg(f.From.UTC()))
+	}
+	if !f.To.IsZero() {
+		where = append(where, "created_at < "+arg(f.To.UTC()))
+	}
+	if f.BeforeID > 0 {
+		where = append(where, "id < "+arg(f.BeforeID))
+	}
+
+	query := `SELECT ` + adminColumns + ` FROM outbox`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	query += ` ORDER BY id DESC LIMIT ` + arg(f.Limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	msgs := []Message{}
+	for rows.Next() {
+		m, err := scanAdmin(rows)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
+
+func (s *PostgresStore) GetMessage(ctx context.Context, id int64) (*Message, error) {
+	m, err := scanAdmin(s.db.QueryRowContext(ctx,
+		`SELECT `+adminColumns+` FROM outbox WHERE id = $1`, id,
+	))
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// RequeueMessages puts processed or quarantined messages back in line for
+// the relay with a fresh attempt budget. Pending messages are left alone.
+// Each requeued message gets a new message ID, also written into its
+// envelope, so consumers that deduplicate on it apply the replay instead
+// of skipping it. It returns how many messages were requeued.
+func (s *PostgresStore) RequeueMessages(ctx context.Context, ids []int64) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+UPDATE outbox o
+SET processed_at = NULL,
+    quarantined_at = NULL,
+    attempts = 0,
+    next_attempt_at = NOW(),
+    message_id = r.message_id,
+    payload = CASE WHEN o.payload->>'specversion' IS NOT NULL
+                   THEN jsonb_set(o.payload, '{id}', to_jsonb(r.message_id))
+                   ELSE o.payload END
+FROM (
+  SELECT id, gen_random_uuid()::text AS message_id
+  FROM outbox
+  WHERE id = ANY($1)
+    AND (processed_at IS NOT NULL OR quarantined_at IS NOT NULL)
+) r
+WHERE o.id = r.id
+`, ids)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `SELECT pg_notify($1, '')`, Channel); err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// PruneMessages deletes up to limit messages processed before the cutoff,
+// oldest first, and returns how many went. With archive set the rows are
+// moved into outbox_archive instead of being dropped.
+func (s *PostgresStore) PruneMessages(ctx context.Context, before time.Time, limit int, archive bool) (int64, error) {
+	before = before.UTC()
+	batch := `
+WITH batch AS (
+  SELECT id FROM outbox
+  WHERE processed_at < $1
+  ORDER BY processed_at
+  LIMIT $2
+  FOR UPDATE SKIP LOCKED
+)
+DELETE FROM outbox o USING batch b WHERE o.id = b.id`
+
+	query := batch
+	if archive {
+		if err := s.ensureArchivePartitions(ctx, before); err != nil {
+			return 0, err
+		}
+		query = `
+WITH moved AS (` + batch + `
+  RETURNING o.id, o.message_id, o.routing_key, o.payload, o.created_at, o.processed_at, o.attempts, o.last_error
+)
+INSERT INTO outbox_archive(id, message_id, routing_key, payload, created_at, processed_at, attempts, last_error)
+SELECT * FROM moved`
+	}
+
+	res, err := s.db.ExecContext(ctx, query, before, limit)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// archiveLockKey is the pg_advisory_xact_lock key held while creating
+// archive partitions, so pruners in several replicas don't race on the
+// same CREATE TABLE.
+const archiveLockKey int64 = 0x6f7574626f786172
+
+// ensureArchivePartitions creates the monthly outbox_archive partitions
+// between the oldest prunable message and the cutoff.
+func (s *PostgresStore) ensureArchivePartitions(ctx context.Context, before time.Time) error {
+	var oldest sql.NullTime
+	err := s.db.QueryRowContext(ctx,
+		`SELECT MIN(processed_at) FROM outbox WHERE processed_at < $1`, before,
+	).Scan(&oldest)
+	if err != nil || !oldest.Valid {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, archiveLockKey); err != nil {
+		return fmt.Errorf("lock archive partitions: %w", err)
+	}
+	month := time.Date(oldest.Time.Year(), oldest.Time.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for !month.After(before) {
+		next := month.AddDate(0, 1, 0)
+		_, err := tx.ExecContext(ctx, fmt.Sprintf(
+			`CREATE TABLE IF NOT EXISTS outbox_archive_%s PARTITION OF outbox_archive FOR VALUES FROM ('%s') TO ('%s')`,
+			month.Format("2006_01"), month.Format(time.DateOnly), next.Format(time.DateOnly),
+		))
+		if err != nil {
+			return fmt.Errorf("create archive partition %s: %w", month.Format("2006-01"), err)
+		}
+		month = next
+	}
+	return tx.Commit()
+}
+
+// Listen LISTENs on Channel over a dedicated connection and signals the
+// returned channel whenever messages are committed. Bursts of notifications
+// collapse into a single signal. The connection is re-established after
+// errors until ctx is done.
+func (s *PostgresStore) Listen(ctx context.Context) <-chan struct{} {
+	wake := make(chan struct{}, 1)
+	go func() {
+		for {
+			err := s.listen(ctx, wake)
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("outbox: listen: %v", err)
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return wake
+}
+
+func (s *PostgresStore) listen(ctx context.Context, wake chan<- struct{}) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var listenErr error
+	_ = conn.Raw(func(dc any) error {
+		pc := dc.(*stdlib.Conn).Conn()
+		if _, listenErr = pc.Exec(ctx, "LISTEN "+Channel); listenErr != nil {
+			return driver.ErrBadConn
+		}
+		// pick up anything committed while nobody was listening
+		signal(wake)
+		for {
+			if _, listenErr = pc.WaitForNotification(ctx); listenErr != nil {
+				// the session is still subscribed; never hand it back to the pool
+				return driver.ErrBadConn
+			}
+			signal(wake)
+		}
+	})
+	return listenErr
+}
+
+func signal(wake chan<- struct{}) {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,41 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"ecom/internal/common"
+)
+
+const (
+	DefaultRetention      = 7 * 24 * time.Hour
+	DefaultPruneBatchSize = 1000
+	DefaultPruneInterval  = time.Hour
+)
+
+// PruneStore is implemented by stores whose processed messages can be
+// cleaned up.
+type PruneStore interface {
+	PruneMessages(ctx context.Context, before time.Time, limit int, archive bool) (int64, error)
+}
+
+// Pruner periodically removes messages that were processed longer than
+// Retention ago, BatchSize rows per statement so the table is never locked
+// for long. With Archive set they are moved to outbox_archive instead.
+// Quarantined messages are never pruned.
+type Pruner struct {
+	*common.Pruner
+
+	Archive bool
+}
+
+func NewPruner(store PruneStore) *Pruner {
+	p := &Pruner{}
+	p.Pruner = common.NewPruner("outbox", func(ctx context.Context, before time.Time, limit int) (int64, error) {
+		return store.PruneMessages(ctx, before, limit, p.Archive)
+	})
+	p.Retention = DefaultRetention
+	p.BatchSize = DefaultPruneBatchSize
+	p.Interval = DefaultPruneInterval
+	return p
+}
@@ -0,0 +1,154 @@
+package outbox
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"ecom/internal/common"
+)
+
+const (
+	DefaultBatchSize   = 10
+	DefaultInterval    = 2 * time.Second
+	DefaultMaxAttempts = 10
+	DefaultBaseBackoff = time.Second
+	DefaultMaxBackoff  = 5 * time.Minute
+
+	DefaultPublishTimeout = 5 * time.Second
+)
+
+// Relay publishes outbox messages to the bus. Any number of relays may run
+// against the same table; each batch is claimed with SKIP LOCKED so a
+// message is only picked up by one of them at a time. If the store is a
+// Listener the relay wakes up as soon as a message is committed and only
+// falls back to polling every Interval.
+type Relay struct {
+	store Store
+	bus   *common.Bus
+
+	BatchSize int
+	Interval  time.Duration
+
+	// PublishTimeout bounds publishing a batch and waiting for its confirms.
+	PublishTimeout time.Duration
+
+	// A message that fails to publish is retried after BaseBackoff, doubling
+	// per attempt up to MaxBackoff, and quarantined once it has failed
+	// MaxAttempts times. Quarantined messages stay in the table for
+	// inspection but are never picked up again on their own.
+	MaxAttempts int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+}
+
+func NewRelay(store Store, bus *common.Bus) *Relay {
+	return &Relay{
+		store:       store,
+		bus:         bus,
+		BatchSize:   DefaultBatchSize,
+		Interval:    DefaultInterval,
+		MaxAttempts: DefaultMaxAttempts,
+		BaseBackoff: DefaultBaseBackoff,
+		MaxBackoff:  DefaultMaxBackoff,
+
+		PublishTimeout: DefaultPublishTimeout,
+	}
+}
+
+// Run relays messages until ctx is done. A batch that has been claimed is
+// always published and recorded before Run returns.
+func (r *Relay) Run(ctx context.Context) {
+	var wake <-chan struct{}
+	if l, ok := r.store.(Listener); ok {
+		wake = l.Listen(ctx)
+	}
+
+	batchCtx := context.WithoutCancel(ctx)
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-wake:
+		}
+
+		// keep draining while full batches go out cleanly
+		for ctx.Err() == nil {
+			more, err := r.relayBatch(batchCtx)
+			if err != nil {
+				log.Printf("relay: claim messages: %v", err)
+				break
+			}
+			if !more {
+				break
+			}
+		}
+	}
+}
+
+// relayBatch reports whether another batch is likely waiting.
+func (r *Relay) relayBatch(ctx context.Context) (bool, error) {
+	var more bool
+	err := r.store.ClaimMessages(ctx, r.BatchSize, func(msgs []Message) []Result {
+		batch := make([]common.Outgoing, len(msgs))
+		for i, m := range msgs {
+			batch[i] = common.Outgoing{MessageID: m.MessageID, RoutingKey: m.RoutingKey, Body: m.Payload}
+		}
+
+		pctx, cancel := context.WithTimeout(ctx, r.PublishTimeout)
+		errs := r.bus.PublishBatch(pctx, batch)
+		cancel()
+
+		// only broker-acked messages come back without an error
+		results := make([]Result, len(msgs))
+		failed, released := 0, 0
+		for i, m := range msgs {
+			results[i] = r.result(m, errs[i])
+			if results[i].Err != nil {
+				failed++
+			}
+			if results[i].Release {
+				released++
+			}
+		}
+		if released > 0 {
+			log.Printf("relay: bus unavailable, %d messages left for later", released)
+		}
+		more = len(msgs) == r.BatchSize && failed == 0
+		return results
+	})
+	return more, err
+}
+
+func (r *Relay) result(m Message, err error) Result {
+	res := Result{ID: m.ID, Err: err}
+	if err == nil {
+		return res
+	}
+	// an outage says nothing about the message, so it costs no attempt
+	if common.IsDisconnected(err) {
+		res.Release = true
+		return res
+	}
+
+	attempts := m.Attempts + 1
+	if attempts >= r.MaxAttempts {
+		res.Quarantine = true
+		log.Printf("relay: quarantine %d after %d attempts: %v", m.ID, attempts, err)
+		return res
+	}
+	res.RetryAt = time.Now().Add(r.backoff(attempts))
+	log.Printf("relay: publish %d (attempt %d): %v", m.ID, attempts, err)
+	return res
+}
+
+func (r *Relay) backoff(attempts int) time.Duration {
+	d := r.BaseBackoff
+	for i := 1; i < attempts && d < r.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, r.MaxBackoff)
+}
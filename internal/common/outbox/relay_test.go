@@ -0,0 +1,68 @@
+package outbox
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"ecom/internal/common"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestRelayResult(t *testing.T) {
+	r := &Relay{MaxAttempts: 3, BaseBackoff: time.Second, MaxBackoff: time.Minute}
+
+	tests := []struct {
+		name       string
+		attempts   int
+		err        error
+		release    bool
+		quarantine bool
+		retry      bool
+	}{
+		{name: "published", attempts: 2},
+		{name: "nacked", attempts: 0, err: common.ErrNacked, retry: true},
+		{name: "nacked on the last attempt", attempts: 2, err: common.ErrNacked, quarantine: true},
+		{name: "confirm timed out", attempts: 0, err: fmt.Errorf("wait for confirm: %w", errors.New("deadline")), retry: true},
+		{name: "bus reconnecting", attempts: 2, err: common.ErrNotConnected, release: true},
+		{name: "bus closed", attempts: 2, err: common.ErrBusClosed, release: true},
+		{name: "channel closed mid-batch", attempts: 2, err: amqp.ErrClosed, release: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := time.Now()
+			res := r.result(Message{ID: 7, Attempts: tt.attempts}, tt.err)
+
+			if res.ID != 7 || res.Err != tt.err {
+				t.Errorf("result = %+v, want ID 7 and Err %v", res, tt.err)
+			}
+			if res.Release != tt.release || res.Quarantine != tt.quarantine {
+				t.Errorf("Release, Quarantine = %v, %v, want %v, %v", res.Release, res.Quarantine, tt.release, tt.quarantine)
+			}
+			if got := !res.RetryAt.IsZero(); got != tt.retry {
+				t.Errorf("RetryAt = %v, want it set: %v", res.RetryAt, tt.retry)
+			}
+			if tt.retry && res.RetryAt.Before(before.Add(r.BaseBackoff)) {
+				t.Errorf("RetryAt = %v, want at least %v from now", res.RetryAt, r.BaseBackoff)
+			}
+		})
+	}
+}
+
+func TestRelayBackoff(t *testing.T) {
+	r := &Relay{BaseBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	for attempts, want := range map[int]time.Duration{
+		1: time.Second,
+		2: 2 * time.Second,
+		3: 4 * time.Second,
+		4: 5 * time.Second,
+		9: 5 * time.Second,
+	} {
+		if got := r.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// Package page implements keyset pagination with opaque cursors.
+//
+// A listing is ordered by one sort column plus the row ID as a tiebreaker.
+// The cursor handed to clients records the sort and the (column, ID) pair
+// of the last row served, so the next page starts right after it no matter
+// what was inserted or deleted in between.
+package page
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
+
+	"ecom/internal/common/apperr"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+// Page is one page of a listing. NextCursor is empty on the last page.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// Request is what a caller asks for; Sort is a field name, prefixed with
+// "-" for descending order.
+type Request struct {
+	Limit  int
+	Cursor string
+	Sort   string
+}
+
+// Cursor is the decoded form of an opaque cursor. Key is the sort column
+// value of the last row, as text.
+type Cursor struct {
+	Sort string `json:"s"`
+	Key  string `json:"k"`
+	ID   string `json:"id"`
+}
+
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Column is a sortable column and the Postgres type its cursor key is cast
+// to.
+type Column struct {
+	Name string
+	Type string
+}
+
+// Query is a validated Request.
+type Query struct {
+	Limit  int
+	Sort   string
+	Column Column
+	Desc   bool
+	// After is nil on the first page.
+	After *Cursor
+}
+
+// Parse validates r against the sortable columns, using def when no sort
+// is given. The limit is clamped to MaxLimit.
+func Parse(r Request, columns map[string]Column, def string) (Query, error) {
+	q := Query{Limit: r.Limit, Sort: r.Sort}
+	if q.Limit <= 0 {
+		q.Limit = DefaultLimit
+	}
+	q.Limit = min(q.Limit, MaxLimit)
+	if q.Sort == "" {
+		q.Sort = def
+	}
+
+	field, desc := strings.CutPrefix(q.Sort, "-")
+	col, ok := columns[field]
+	if !ok {
+		names := make([]string, 0, len(columns))
+		for name := range columns {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		return Query{}, apperr.Validation("invalid_sort", "unknown sort field",
+			apperr.FieldError{Field: "sort", Message: "must be one of " + strings.Join(names, ", ") + ", optionally prefixed with -"})
+	}
+	q.Column, q.Desc = col, desc
+
+	if r.Cursor != "" {
+		c, err := decode(r.Cursor)
+		if err != nil || c.Sort != q.Sort {
+			return Query{}, apperr.Validation("invalid_cursor", "cursor is malformed or was issued for another sort",
+				apperr.FieldError{Field: "cursor", Message: "is not valid for this listing"})
+		}
+		q.After = c
+	}
+	return q, nil
+}
+
+func decode(s string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Seek returns the condition selecting the rows after the cursor, using
+// placeholders $n and $n+1, and its arguments. It is empty on the first
+// page.
+func (q Query) Seek(n int) (string, []any) {
+	if q.After == nil {
+		return "", nil
+	}
+	op := ">"
+	if q.Desc {
+		op = "<"
+	}
+	return "(" + q.Column.Name + ", id) " + op + " ($" + strconv.Itoa(n) + "::" + q.Column.Type + ", $" + strconv.Itoa(n+1) + ")",
+		[]any{q.After.Key, q.After.ID}
+}
+
+// OrderBy returns the ORDER BY and LIMIT clauses. One row more than the
+// limit is fetched to tell whether there is a next page.
+func (q Query) OrderBy() string {
+	dir := "ASC"
+	if q.Desc {
+		dir = "DESC"
+	}
+	return " ORDER BY " + q.Column.Name + " " + dir + ", id " + dir + " LIMIT " + strconv.Itoa(q.Limit+1)
+}
+
+// Finish trims the extra row fetched by OrderBy and builds the page, using
+// key to get the sort key and ID of the last item.
+func Finish[T any](q Query, items []T, key func(T) (sortKey, id string)) *Page[T] {
+	p := &Page[T]{Items: items}
+	if p.Items == nil {
+		p.Items = []T{}
+	}
+	if len(items) > q.Limit {
+		p.Items = items[:q.Limit]
+		k, id := key(p.Items[q.Limit-1])
+		p.NextCursor = Cursor{Sort: q.Sort, Key: k, ID: id}.Encode()
+	}
+	return p
+}
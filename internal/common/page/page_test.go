@@ -0,0 +1,224 @@
+package page
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"ecom/internal/common/apperr"
+)
+
+var columns = map[string]Column{
+	"createdAt": {Name: "created_at", Type: "timestamptz"},
+	"price":     {Name: "price", Type: "numeric"},
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []Cursor{
+		{Sort: "createdAt", Key: "2024-05-01T12:00:00Z", ID: "a1"},
+		{Sort: "-price", Key: "19.99", ID: "b2"},
+		{Sort: "price", Key: "", ID: ""},
+		{Sort: "createdAt", Key: `quotes " and / slashes`, ID: "c3"},
+	}
+	for _, want := range tests {
+		t.Run(want.Sort+"/"+want.ID, func(t *testing.T) {
+			got, err := decode(want.Encode())
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if *got != want {
+				t.Errorf("decode(Encode()) = %+v, want %+v", *got, want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	desc := Cursor{Sort: "-createdAt", Key: "2024-05-01T12:00:00Z", ID: "a1"}
+
+	tests := []struct {
+		name  string
+		req   Request
+		want  Query
+		code  string
+		field string
+	}{
+		{
+			name: "defaults",
+			req:  Request{},
+			want: Query{Limit: DefaultLimit, Sort: "-createdAt", Column: columns["createdAt"], Desc: true},
+		},
+		{
+			name: "negative limit",
+			req:  Request{Limit: -5, Sort: "price"},
+			want: Query{Limit: DefaultLimit, Sort: "price", Column: columns["price"]},
+		},
+		{
+			name: "limit kept",
+			req:  Request{Limit: 50, Sort: "price"},
+			want: Query{Limit: 50, Sort: "price", Column: columns["price"]},
+		},
+		{
+			name: "limit clamped",
+			req:  Request{Limit: 1000, Sort: "price"},
+			want: Query{Limit: MaxLimit, Sort: "price", Column: columns["price"]},
+		},
+		{
+			name: "cursor for the same sort",
+			req:  Request{Cursor: desc.Encode()},
+			want: Query{Limit: DefaultLimit, Sort: "-createdAt", Column: columns["createdAt"], Desc: true, After: &desc},
+		},
+		{
+			name:  "cursor for another direction",
+			req:   Request{Sort: "createdAt", Cursor: desc.Encode()},
+			code:  "invalid_cursor",
+			field: "cursor",
+		},
+		{
+			name:  "cursor for another column",
+			req:   Request{Sort: "-price", Cursor: desc.Encode()},
+			code:  "invalid_cursor",
+			field: "cursor",
+		},
+		{
+			name:  "cursor not base64",
+			req:   Request{Cursor: "not a cursor!"},
+			code:  "invalid_cursor",
+			field: "cursor",
+		},
+		{
+			name:  "cursor not json",
+			req:   Request{Cursor: base64.RawURLEncoding.EncodeToString([]byte("nope"))},
+			code:  "invalid_cursor",
+			field: "cursor",
+		},
+		{
+			name:  "unknown sort",
+			req:   Request{Sort: "name"},
+			code:  "invalid_sort",
+			field: "sort",
+		},
+		{
+			name:  "unknown descending sort",
+			req:   Request{Sort: "--price"},
+			code:  "invalid_sort",
+			field: "sort",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.req, columns, "-createdAt")
+			if tt.code != "" {
+				var appErr *apperr.Error
+				if !errors.As(err, &appErr) || !errors.Is(err, apperr.ErrValidation) {
+					t.Fatalf("err = %v, want a validation error", err)
+				}
+				if appErr.Code != tt.code {
+					t.Errorf("code = %q, want %q", appErr.Code, tt.code)
+				}
+				if len(appErr.Fields) != 1 || appErr.Fields[0].Field != tt.field {
+					t.Errorf("fields = %+v, want one for %q", appErr.Fields, tt.field)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if got.Limit != tt.want.Limit || got.Sort != tt.want.Sort || got.Column != tt.want.Column || got.Desc != tt.want.Desc {
+				t.Errorf("Parse = %+v, want %+v", got, tt.want)
+			}
+			switch {
+			case tt.want.After == nil && got.After != nil:
+				t.Errorf("After = %+v, want nil", *got.After)
+			case tt.want.After != nil && (got.After == nil || *got.After != *tt.want.After):
+				t.Errorf("After = %v, want %+v", got.After, *tt.want.After)
+			}
+		})
+	}
+}
+
+func TestFinish(t *testing.T) {
+	key := func(s string) (string, string) { return "k-" + s, s }
+
+	tests := []struct {
+		name  string
+		items []string
+		want  []string
+		next  *Cursor
+	}{
+		{name: "nil", items: nil, want: []string{}},
+		{name: "short page", items: []string{"a", "b"}, want: []string{"a", "b"}},
+		{name: "exactly full", items: []string{"a", "b", "c"}, want: []string{"a", "b", "c"}},
+		{
+			name:  "extra row",
+			items: []string{"a", "b", "c", "d"},
+			want:  []string{"a", "b", "c"},
+			next:  &Cursor{Sort: "-price", Key: "k-c", ID: "c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Finish(Query{Limit: 3, Sort: "-price"}, tt.items, key)
+			if p.Items == nil || len(p.Items) != len(tt.want) {
+				t.Fatalf("Items = %#v, want %#v", p.Items, tt.want)
+			}
+			for i := range tt.want {
+				if p.Items[i] != tt.want[i] {
+					t.Errorf("Items = %v, want %v", p.Items, tt.want)
+					break
+				}
+			}
+
+			if tt.next == nil {
+				if p.NextCursor != "" {
+					t.Errorf("NextCursor = %q, want none", p.NextCursor)
+				}
+				return
+			}
+			c, err := decode(p.NextCursor)
+			if err != nil {
+				t.Fatalf("decode NextCursor: %v", err)
+			}
+			if *c != *tt.next {
+				t.Errorf("NextCursor = %+v, want %+v", *c, *tt.next)
+			}
+		})
+	}
+}
+
+func TestSeek(t *testing.T) {
+	tests := []struct {
+		name  string
+		q     Query
+		where string
+	}{
+		{name: "first page", q: Query{Column: columns["price"]}},
+		{
+			name:  "ascending",
+			q:     Query{Column: columns["price"], After: &Cursor{Key: "5", ID: "x"}},
+			where: "(price, id) > ($3::numeric, $4)",
+		},
+		{
+			name:  "descending",
+			q:     Query{Column: columns["createdAt"], Desc: true, After: &Cursor{Key: "5", ID: "x"}},
+			where: "(created_at, id) < ($3::timestamptz, $4)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			where, args := tt.q.Seek(3)
+			if where != tt.where {
+				t.Errorf("Seek = %q, want %q", where, tt.where)
+			}
+			if tt.where == "" && args != nil {
+				t.Errorf("args = %v, want none", args)
+			}
+			if tt.where != "" && (len(args) != 2 || args[0] != "5" || args[1] != "x") {
+				t.Errorf("args = %v, want [5 x]", args)
+			}
+		})
+	}
+}
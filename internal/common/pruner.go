@@ -0,0 +1,66 @@
+package common
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// PruneFunc removes at most limit rows that were processed before the given
+// time and reports how many it removed.
+type PruneFunc func(ctx context.Context, before time.Time, limit int) (int64, error)
+
+// Pruner periodically removes rows that were processed longer than Retention
+// ago, BatchSize rows per call so the table is never locked for long.
+type Pruner struct {
+	name string
+	fn   PruneFunc
+
+	Retention time.Duration
+	BatchSize int
+	Interval  time.Duration
+}
+
+// NewPruner returns a Pruner that calls prune and logs under name. Callers
+// set Retention, BatchSize and Interval.
+func NewPruner(name string, prune PruneFunc) *Pruner {
+	return &Pruner{name: name, fn: prune}
+}
+
+// Run prunes every Interval until ctx is done.
+func (p *Pruner) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for {
+		p.prune(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// prune removes everything older than Retention, batch by batch, and
+// returns how many rows went.
+func (p *Pruner) prune(ctx context.Context) int64 {
+	before := time.Now().Add(-p.Retention)
+	var total int64
+	for ctx.Err() == nil {
+		n, err := p.fn(ctx, before, p.BatchSize)
+		total += n
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("%s: prune: %v", p.name, err)
+			}
+			break
+		}
+		if n < int64(p.BatchSize) {
+			break
+		}
+	}
+	if total > 0 {
+		log.Printf("%s: removed %d rows processed before %s", p.name, total, before.Format(time.RFC3339))
+	}
+	return total
+}
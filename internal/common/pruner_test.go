@@ -0,0 +1,58 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPrunerBatches(t *testing.T) {
+	tests := []struct {
+		name      string
+		batches   []int64
+		err       error
+		wantCalls int
+		wantTotal int64
+	}{
+		{name: "nothing to prune", batches: []int64{0}, wantCalls: 1},
+		{name: "short batch ends the run", batches: []int64{10, 10, 3}, wantCalls: 3, wantTotal: 23},
+		{name: "full last batch needs one more call", batches: []int64{10, 10, 0}, wantCalls: 3, wantTotal: 20},
+		{name: "error stops the run", batches: []int64{10, 10}, err: errors.New("boom"), wantCalls: 2, wantTotal: 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			var before time.Time
+			p := NewPruner("test", func(_ context.Context, b time.Time, limit int) (int64, error) {
+				if limit != 10 {
+					t.Errorf("limit = %d, want 10", limit)
+				}
+				if calls > 0 && !b.Equal(before) {
+					t.Errorf("cutoff moved from %v to %v within one run", before, b)
+				}
+				before = b
+				n := tt.batches[calls]
+				calls++
+				if calls == len(tt.batches) {
+					return n, tt.err
+				}
+				return n, nil
+			})
+			p.Retention = time.Hour
+			p.BatchSize = 10
+
+			start := time.Now()
+			if got := p.prune(context.Background()); got != tt.wantTotal {
+				t.Errorf("prune = %d, want %d", got, tt.wantTotal)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if cutoff := start.Add(-time.Hour); before.Before(cutoff) {
+				t.Errorf("cutoff = %v, want no earlier than %v", before, cutoff)
+			}
+		})
+	}
+}
@@ -3,63 +3,225 @@ package common
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"log"
+	"sync"
 	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+var (
+	// ErrNacked is returned when the broker refuses to take a message.
+	ErrNacked = errors.New("amqp: message nacked by broker")
+	// ErrNotConnected is returned while the bus is reconnecting.
+	ErrNotConnected = errors.New("amqp: not connected")
+	// ErrBusClosed is returned once Close has been called.
+	ErrBusClosed = errors.New("amqp: bus closed")
+)
+
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+
+	// per-worker queue length of a keyed Serve without a prefetch limit
+	defaultLaneBuffer = 64
+
+	retryCountHeader = "x-retry-count"
+	// retries come back through the default exchange with the queue name
+	// as routing key; the original one travels in this header
+	routingKeyHeader = "x-original-routing-key"
+)
+
+// RetryDelays are the TTL tiers of the retry queues declared next to every
+// consumed queue. A message handed to Consumer.Retry waits out the next tier
+// and is dead-lettered once all tiers are used up.
+var RetryDelays = []time.Duration{5 * time.Second, 30 * time.Second, 5 * time.Minute}
+
+// Bus is a RabbitMQ connection that heals itself. When the connection or
+// channel drops it reconnects with backoff, redeclares the exchange and
+// every queue and binding created through Consume, and resubscribes the
+// consumers, whose Deliveries channels stay open throughout.
 type Bus struct {
-	Conn *amqp.Connection
-	Ch   *amqp.Channel
+	url string
+
+	mu     sync.RWMutex
+	conn   *amqp.Connection
+	ch     *amqp.Channel
+	ready  chan struct{} // closed while connected
+	queues []queueSpec
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type queueSpec struct {
+	name        string
+	routingKeys []string
+}
+
+// Outgoing is one message of a PublishBatch. MessageID, if set, is sent as
+// the AMQP message-id property for consumers to deduplicate on.
+type Outgoing struct {
+	MessageID  string
+	RoutingKey string
+	Body       []byte
 }
 
 func Connect(amqpURL string) (*Bus, error) {
-	conn, err := amqp.Dial(amqpURL)
-	if err != nil {
+	b := &Bus{
+		url:   amqpURL,
+		ready: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	if err := b.connect(); err != nil {
 		return nil, err
 	}
+	return b, nil
+}
+
+func (b *Bus) connect() error {
+	conn, err := amqp.Dial(b.url)
+	if err != nil {
+		return err
+	}
 	ch, err := conn.Channel()
 	if err != nil {
 		conn.Close()
-		return nil, err
+		return err
 	}
 	// topic exchange for domain events
 	if err := ch.ExchangeDeclare(
 		ExchangeName, "topic", true, false, false, false, nil,
 	); err != nil {
-		ch.Close()
 		conn.Close()
+		return err
+	}
+	// every publish is acked or nacked by the broker
+	if err := ch.Confirm(false); err != nil {
+		conn.Close()
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, q := range b.queues {
+		if err := declareQueue(ch, q); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	b.conn, b.ch = conn, ch
+	close(b.ready)
+
+	go b.watch(conn, ch)
+	return nil
+}
+
+// watch waits for conn or ch to go away and starts reconnecting.
+func (b *Bus) watch(conn *amqp.Connection, ch *amqp.Channel) {
+	var amqpErr *amqp.Error
+	select {
+	case amqpErr = <-conn.NotifyClose(make(chan *amqp.Error, 1)):
+	case amqpErr = <-ch.NotifyClose(make(chan *amqp.Error, 1)):
+	}
+
+	b.mu.Lock()
+	b.ready = make(chan struct{})
+	b.mu.Unlock()
+	_ = conn.Close()
+
+	delay := minReconnectDelay
+	for {
+		select {
+		case <-b.done:
+			return
+		default:
+		}
+		log.Printf("amqp: connection lost (%v), reconnecting in %s", amqpErr, delay)
+
+		select {
+		case <-b.done:
+			return
+		case <-time.After(delay):
+		}
+		if err := b.connect(); err != nil {
+			log.Printf("amqp: reconnect: %v", err)
+			delay = min(delay*2, maxReconnectDelay)
+			continue
+		}
+		log.Printf("amqp: reconnected")
+		return
+	}
+}
+
+// channel returns the publishing channel, or ErrNotConnected while the bus
+// is reconnecting.
+func (b *Bus) channel() (*amqp.Channel, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if err := b.state(); err != nil {
 		return nil, err
 	}
-	return &Bus{Conn: conn, Ch: ch}, nil
+	return b.ch, nil
 }
 
-func (b *Bus) Close() {
-	if b.Ch != nil {
-		_ = b.Ch.Close()
+// state reports whether the bus is usable. Callers must hold mu.
+func (b *Bus) state() error {
+	select {
+	case <-b.done:
+		return ErrBusClosed
+	case <-b.ready:
+		return nil
+	default:
+		return ErrNotConnected
 	}
-	if b.Conn != nil {
-		_ = b.Conn.Close()
+}
+
+// waitConnection blocks until the bus is connected or stop is closed.
+func (b *Bus) waitConnection(stop <-chan struct{}) (*amqp.Connection, error) {
+	for {
+		b.mu.RLock()
+		ready := b.ready
+		b.mu.RUnlock()
+
+		select {
+		case <-b.done:
+			return nil, ErrBusClosed
+		case <-stop:
+			return nil, context.Canceled
+		case <-ready:
+		}
+
+		b.mu.RLock()
+		conn, err := b.conn, b.state()
+		b.mu.RUnlock()
+		if err != ErrNotConnected {
+			return conn, err
+		}
 	}
 }
 
+func (b *Bus) Close() {
+	b.closeOnce.Do(func() {
+		close(b.done)
+		b.mu.RLock()
+		defer b.mu.RUnlock()
+		if b.conn != nil {
+			_ = b.conn.Close()
+		}
+	})
+}
+
+// Publish sends body and waits for the broker to confirm it. A nil error
+// means the broker has taken responsibility for the message.
 func (b *Bus) Publish(routingKey string, body []byte) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 
-	return b.Ch.PublishWithContext(ctx,
-		ExchangeName,
-		routingKey,
-		false,
-		false,
-		amqp.Publishing{
-			ContentType:  "application/json",
-			Body:         body,
-			DeliveryMode: amqp.Persistent,
-			Timestamp:    time.Now(),
-		},
-	)
+	return b.PublishBatch(ctx, []Outgoing{{RoutingKey: routingKey, Body: body}})[0]
 }
 
 func (b *Bus) PublishJSON(routingKey string, v any) error {
@@ -67,40 +229,470 @@ func (b *Bus) PublishJSON(routingKey string, v any) error {
 	if err != nil {
 		return err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer cancel()
+	return b.Publish(routingKey, body)
+}
+
+// PublishBatch sends all messages before waiting for any confirmation, so a
+// batch costs one round trip instead of one per message. The returned slice
+// holds the outcome of each message in order.
+func (b *Bus) PublishBatch(ctx context.Context, msgs []Outgoing) []error {
+	errs := make([]error, len(msgs))
+
+	ch, err := b.channel()
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+
+	confirms := make([]*amqp.DeferredConfirmation, len(msgs))
+	for i, m := range msgs {
+		confirms[i], errs[i] = ch.PublishWithDeferredConfirmWithContext(ctx,
+			ExchangeName,
+			m.RoutingKey,
+			false,
+			false,
+			amqp.Publishing{
+				ContentType:  "application/json",
+				Body:         m.Body,
+				DeliveryMode: amqp.Persistent,
+				MessageId:    m.MessageID,
+				Timestamp:    time.Now(),
+			},
+		)
+	}
+
+	for i, dc := range confirms {
+		if errs[i] == nil {
+			errs[i] = waitConfirm(ctx, ch, dc)
+		}
+	}
+	return errs
+}
+
+// publishDirect sends msg straight to a queue through the default exchange
+// and waits for the confirm.
+func (b *Bus) publishDirect(ctx context.Context, queueName string, msg amqp.Publishing) error {
+	ch, err := b.channel()
+	if err != nil {
+		return err
+	}
+	dc, err := ch.PublishWithDeferredConfirmWithContext(ctx, "", queueName, false, false, msg)
+	if err != nil {
+		return err
+	}
+	return waitConfirm(ctx, ch, dc)
+}
 
-	return b.Ch.PublishWithContext(ctx,
-		ExchangeName,
-		routingKey,
-		false,
-		false,
-		amqp.Publishing{
-			ContentType:  "application/json",
-			Body:         body,
-			DeliveryMode: amqp.Persistent,
-			Timestamp:    time.Now(),
-		},
-	)
+// IsDisconnected reports whether err means a message never reached a live
+// broker, as opposed to the broker refusing it.
+func IsDisconnected(err error) bool {
+	return errors.Is(err, ErrNotConnected) || errors.Is(err, ErrBusClosed) || errors.Is(err, amqp.ErrClosed)
 }
 
+// waitConfirm waits for the broker's verdict on dc. Confirms still pending
+// when ch closes are reported as nacks by the client library; those are
+// returned as amqp.ErrClosed since the broker never refused them.
+func waitConfirm(ctx context.Context, ch *amqp.Channel, dc *amqp.DeferredConfirmation) error {
+	acked, err := dc.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("wait for confirm: %w", err)
+	}
+	if !acked {
+		if ch.IsClosed() {
+			return amqp.ErrClosed
+		}
+		return ErrNacked
+	}
+	return nil
+}
+
+// ConsumeOptions tune a consumer.
+type ConsumeOptions struct {
+	// Prefetch caps the unacked deliveries the broker hands out at once.
+	// Zero means no limit.
+	Prefetch int
+	// Workers is how many deliveries Serve handles concurrently.
+	Workers int
+	// Key, if set, pins deliveries with the same key to the same worker so
+	// they are handled in order.
+	Key func(d amqp.Delivery) string
+	// Replaces names queues this one takes over from. Their bindings are
+	// removed, whatever they still hold is moved over, and they are
+	// deleted once empty and no longer consumed.
+	Replaces []string
+}
+
+// Consumer delivers messages from one queue over a channel of its own.
+// Deliveries survives reconnects and is only closed by Cancel or when the
+// bus is closed.
+//
+// Every consumed queue Q comes with a dead-letter exchange Q.dlx feeding
+// the queue Q.dlq, and one retry queue Q.retry.<delay> per RetryDelays tier
+// whose messages flow back into Q once their TTL expires. Settle each
+// delivery with Ack, Retry or Reject.
 type Consumer struct {
 	Deliveries <-chan amqp.Delivery
+
+	bus   *Bus
+	queue string
+	opts  ConsumeOptions
+
+	mu       sync.Mutex
+	ch       *amqp.Channel // current consuming channel
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// Cancel stops handing out deliveries and closes Deliveries. Deliveries
+// already handed out can still be settled; call Close once they are.
+func (c *Consumer) Cancel() {
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
+// Close cancels the consumer and closes its channel. The broker requeues
+// whatever was prefetched but never settled.
+func (c *Consumer) Close() {
+	c.Cancel()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ch != nil {
+		_ = c.ch.Close()
+	}
+}
+
+// Serve hands every delivery to handle on opts.Workers goroutines and
+// returns once Deliveries is closed and all handlers have returned. With a
+// Key, each worker has a queue of its own holding its share of the
+// prefetch window, so a slow key only holds back the keys that hash to the
+// same worker, and everything else once that queue is full.
+func (c *Consumer) Serve(handle func(d amqp.Delivery)) {
+	workers := max(c.opts.Workers, 1)
+	var wg sync.WaitGroup
+
+	if c.opts.Key == nil {
+		for range workers {
+			wg.Go(func() {
+				for d := range c.Deliveries {
+					handle(d)
+				}
+			})
+		}
+		wg.Wait()
+		return
+	}
+
+	buffer := defaultLaneBuffer
+	if c.opts.Prefetch > 0 {
+		buffer = max(c.opts.Prefetch/workers, 1)
+	}
+	lanes := make([]chan amqp.Delivery, workers)
+	for i := range lanes {
+		lanes[i] = make(chan amqp.Delivery, buffer)
+		wg.Go(func() {
+			for d := range lanes[i] {
+				handle(d)
+			}
+		})
+	}
+	for d := range c.Deliveries {
+		h := fnv.New32a()
+		h.Write([]byte(c.opts.Key(d)))
+		lanes[h.Sum32()%uint32(workers)] <- d
+	}
+	for _, lane := range lanes {
+		close(lane)
+	}
+	wg.Wait()
 }
 
-func (b *Bus) Consume(queueName string, routingKeys ...string) (*Consumer, error) {
-	q, err := b.Ch.QueueDeclare(queueName, true, false, false, false, nil)
+// Retry acks d and schedules a copy of it on the next retry tier. Once the
+// tiers are used up d is rejected into the dead-letter queue instead. If the
+// copy cannot be published d is requeued as a last resort.
+func (c *Consumer) Retry(d amqp.Delivery) error {
+	n := retryCount(d)
+	if n >= len(RetryDelays) {
+		return c.Reject(d)
+	}
+
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[retryCountHeader] = int32(n + 1)
+	headers[routingKeyHeader] = RoutingKey(d)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	err := c.bus.publishDirect(ctx, retryQueueName(c.queue, RetryDelays[n]), amqp.Publishing{
+		Headers:         headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		Body:            d.Body,
+	})
+	if err != nil {
+		_ = d.Nack(false, true)
+		return fmt.Errorf("schedule retry: %w", err)
+	}
+	return d.Ack(false)
+}
+
+// Reject moves d to the dead-letter queue.
+func (c *Consumer) Reject(d amqp.Delivery) error {
+	return d.Nack(false, false)
+}
+
+// RoutingKey is the key d was originally published with, which for a
+// retried delivery differs from d.RoutingKey.
+func RoutingKey(d amqp.Delivery) string {
+	if key, ok := d.Headers[routingKeyHeader].(string); ok && key != "" {
+		return key
+	}
+	return d.RoutingKey
+}
+
+func retryCount(d amqp.Delivery) int {
+	switch v := d.Headers[retryCountHeader].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	}
+	return 0
+}
+
+// retryQueueName gives names like orders.products_cache.retry.30s.
+func retryQueueName(queueName string, delay time.Duration) string {
+	if delay%time.Minute == 0 {
+		return fmt.Sprintf("%s.retry.%dm", queueName, delay/time.Minute)
+	}
+	return fmt.Sprintf("%s.retry.%ds", queueName, delay/time.Second)
+}
+
+func (b *Bus) Consume(queueName string, opts ConsumeOptions, routingKeys ...string) (*Consumer, error) {
+	q := queueSpec{name: queueName, routingKeys: routingKeys}
+
+	// declare and remember the queue in one go so a concurrent reconnect
+	// cannot miss it
+	b.mu.Lock()
+	conn, err := b.conn, b.state()
+	if err == nil {
+		err = declareQueue(b.ch, q)
+	}
+	if err == nil {
+		b.queues = append(b.queues, q)
+	}
+	b.mu.Unlock()
 	if err != nil {
 		return nil, err
 	}
-	for _, key := range routingKeys {
-		if err := b.Ch.QueueBind(q.Name, key, ExchangeName, false, nil); err != nil {
-			return nil, fmt.Errorf("bind %s: %w", key, err)
+
+	out := make(chan amqp.Delivery)
+	c := &Consumer{
+		Deliveries: out,
+		bus:        b,
+		queue:      q.name,
+		opts:       opts,
+		stop:       make(chan struct{}),
+	}
+	deliveries, err := c.subscribe(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	go c.forward(deliveries, out)
+	for _, old := range opts.Replaces {
+		go b.drain(conn, old, q)
+	}
+	return c, nil
+}
+
+// drain moves the messages of the superseded queue old into q and deletes
+// old. Moving to a new name lets q carry arguments old was declared
+// without, which redeclaring old in place would fail on.
+func (b *Bus) drain(conn *amqp.Connection, old string, q queueSpec) {
+	ch, err := conn.Channel()
+	if err != nil {
+		log.Printf("amqp: drain %s: %v", old, err)
+		return
+	}
+	defer ch.Close()
+
+	// a missing queue closes the channel, which is why drain has its own
+	if _, err := ch.QueueDeclarePassive(old, true, false, false, false, nil); err != nil {
+		return
+	}
+	for _, key := range q.routingKeys {
+		if err := ch.QueueUnbind(old, key, ExchangeName, nil); err != nil {
+			log.Printf("amqp: drain %s: unbind %s: %v", old, key, err)
+			return
 		}
 	}
-	deliveries, err := b.Ch.Consume(q.Name, "", false, false, false, false, nil)
+
+	moved := 0
+	for {
+		d, ok, err := ch.Get(old, false)
+		if err != nil {
+			log.Printf("amqp: drain %s: %v", old, err)
+			return
+		}
+		if !ok {
+			break
+		}
+		headers := amqp.Table{}
+		for k, v := range d.Headers {
+			headers[k] = v
+		}
+		headers[routingKeyHeader] = RoutingKey(d)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		err = b.publishDirect(ctx, q.name, amqp.Publishing{
+			Headers:         headers,
+			ContentType:     d.ContentType,
+			ContentEncoding: d.ContentEncoding,
+			DeliveryMode:    amqp.Persistent,
+			MessageId:       d.MessageId,
+			Timestamp:       d.Timestamp,
+			Type:            d.Type,
+			Body:            d.Body,
+		})
+		cancel()
+		if err != nil {
+			_ = d.Nack(false, true)
+			log.Printf("amqp: drain %s: %v", old, err)
+			return
+		}
+		if err := d.Ack(false); err != nil {
+			log.Printf("amqp: drain %s: %v", old, err)
+			return
+		}
+		moved++
+	}
+
+	// an older build still consuming old keeps it alive until it is gone
+	if _, err := ch.QueueDelete(old, true, true, false); err != nil {
+		log.Printf("amqp: drain %s: moved %d messages, not deleted: %v", old, moved, err)
+		return
+	}
+	log.Printf("amqp: drained %s into %s: moved %d messages", old, q.name, moved)
+}
+
+// subscribe opens a dedicated channel on conn and starts consuming from it.
+func (c *Consumer) subscribe(conn *amqp.Connection) (<-chan amqp.Delivery, error) {
+	ch, err := conn.Channel()
 	if err != nil {
 		return nil, err
 	}
-	return &Consumer{Deliveries: deliveries}, nil
+	if c.opts.Prefetch > 0 {
+		if err := ch.Qos(c.opts.Prefetch, 0, false); err != nil {
+			ch.Close()
+			return nil, err
+		}
+	}
+	deliveries, err := ch.Consume(c.queue, "", false, false, false, false, nil)
+	if err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.ch = ch
+	c.mu.Unlock()
+	return deliveries, nil
+}
+
+// forward copies deliveries to out, subscribing again on a new channel
+// whenever the current one goes away.
+func (c *Consumer) forward(deliveries <-chan amqp.Delivery, out chan<- amqp.Delivery) {
+	defer close(out)
+	for {
+		if !c.pipe(deliveries, out) {
+			return
+		}
+
+		for {
+			conn, err := c.bus.waitConnection(c.stop)
+			if err != nil {
+				return
+			}
+			deliveries, err = c.subscribe(conn)
+			if err == nil {
+				break
+			}
+			log.Printf("amqp: resubscribe %s: %v", c.queue, err)
+			select {
+			case <-c.stop:
+				return
+			case <-c.bus.done:
+				return
+			case <-time.After(minReconnectDelay):
+			}
+		}
+	}
+}
+
+// pipe forwards until deliveries closes, reporting false if the consumer
+// was stopped instead.
+func (c *Consumer) pipe(deliveries <-chan amqp.Delivery, out chan<- amqp.Delivery) bool {
+	for {
+		select {
+		case d, ok := <-deliveries:
+			if !ok {
+				return true
+			}
+			select {
+			case out <- d:
+			case <-c.stop:
+				return false
+			case <-c.bus.done:
+				return false
+			}
+		case <-c.stop:
+			return false
+		case <-c.bus.done:
+			return false
+		}
+	}
+}
+
+func declareQueue(ch *amqp.Channel, q queueSpec) error {
+	dlx, dlq := q.name+".dlx", q.name+".dlq"
+	if err := ch.ExchangeDeclare(dlx, "fanout", true, false, false, false, nil); err != nil {
+		return err
+	}
+	if _, err := ch.QueueDeclare(dlq, true, false, false, false, nil); err != nil {
+		return err
+	}
+	if err := ch.QueueBind(dlq, "", dlx, false, nil); err != nil {
+		return fmt.Errorf("bind %s: %w", dlq, err)
+	}
+
+	for _, delay := range RetryDelays {
+		_, err := ch.QueueDeclare(retryQueueName(q.name, delay), true, false, false, false, amqp.Table{
+			"x-message-ttl":             delay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": q.name,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := ch.QueueDeclare(q.name, true, false, false, false, amqp.Table{
+		"x-dead-letter-exchange": dlx,
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range q.routingKeys {
+		if err := ch.QueueBind(q.name, key, ExchangeName, false, nil); err != nil {
+			return fmt.Errorf("bind %s: %w", key, err)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,78 @@
+package common
+
+import (
+	"hash/fnv"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestServeKeyedSlowKeyDoesNotStallOthers(t *testing.T) {
+	const workers = 2
+	lane := func(key string) uint32 {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		return h.Sum32() % workers
+	}
+	// find a key that lands on the other worker than "slow"
+	fast := "fast"
+	for lane(fast) == lane("slow") {
+		fast += "x"
+	}
+
+	deliveries := make(chan amqp.Delivery)
+	c := &Consumer{
+		Deliveries: deliveries,
+		opts: ConsumeOptions{
+			Prefetch: 8,
+			Workers:  workers,
+			Key:      func(d amqp.Delivery) string { return string(d.Body) },
+		},
+	}
+
+	release := make(chan struct{})
+	handled := make(chan string, 16)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Serve(func(d amqp.Delivery) {
+			if string(d.Body) == "slow" {
+				<-release
+			}
+			handled <- string(d.Body)
+		})
+	}()
+
+	// the slow worker's queue holds Prefetch/Workers deliveries, so these
+	// go through without blocking the dispatcher
+	send := func(body string) {
+		select {
+		case deliveries <- amqp.Delivery{Body: []byte(body)}:
+		case <-time.After(time.Second):
+			t.Fatalf("dispatcher stalled sending %q", body)
+		}
+	}
+	send("slow")
+	send("slow")
+	for range 5 {
+		send(fast)
+	}
+	for range 5 {
+		select {
+		case got := <-handled:
+			if got != fast {
+				t.Fatalf("handled %q while the slow key was still blocked", got)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("fast key stalled behind the slow one")
+		}
+	}
+
+	close(release)
+	close(deliveries)
+	<-done
+	if n := len(handled); n != 2 {
+		t.Errorf("handled %d slow deliveries after release, want 2", n)
+	}
+}
@@ -0,0 +1,172 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const DefaultHandlerTimeout = 2 * time.Second
+
+// HandlerFunc handles one decoded event. env carries the event metadata;
+// its ID is the message ID to deduplicate on.
+type HandlerFunc[T any] func(ctx context.Context, env *Envelope, evt T) error
+
+// SubscribeOptions tune a Subscriber.
+type SubscribeOptions struct {
+	ConsumeOptions
+	// Timeout bounds each handler call.
+	Timeout time.Duration
+}
+
+// Subscriber consumes one queue and dispatches every delivery to the
+// handler registered for its event type. Deliveries are settled according
+// to the handler's result:
+//
+//   - nil: acked
+//   - an error wrapped with Permanent, an undecodable body or an event type
+//     without a handler: rejected into the dead-letter queue
+//   - any other error, a timeout or a panic: retried through the retry tiers
+type Subscriber struct {
+	bus      *Bus
+	queue    string
+	opts     SubscribeOptions
+	handlers map[string]func(ctx context.Context, env *Envelope) error
+
+	cons    *Consumer
+	stopped chan struct{}
+}
+
+func NewSubscriber(bus *Bus, queue string, opts SubscribeOptions) *Subscriber {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultHandlerTimeout
+	}
+	return &Subscriber{
+		bus:      bus,
+		queue:    queue,
+		opts:     opts,
+		handlers: map[string]func(ctx context.Context, env *Envelope) error{},
+	}
+}
+
+// Handle registers fn for eventType, which is also bound as a routing key.
+// Register every handler before calling Start.
+func Handle[T any](s *Subscriber, eventType string, fn HandlerFunc[T]) {
+	s.handlers[eventType] = func(ctx context.Context, env *Envelope) error {
+		evt, err := DecodeEvent[T](env)
+		if err != nil {
+			if errors.Is(err, ErrNewerVersion) {
+				return err
+			}
+			return Permanent(fmt.Errorf("decode: %w", err))
+		}
+		return fn(ctx, env, evt)
+	}
+}
+
+// Start declares the queue with a binding per registered event type and
+// serves it in the background until Stop is called or the bus is closed.
+func (s *Subscriber) Start() error {
+	keys := make([]string, 0, len(s.handlers))
+	for k := range s.handlers {
+		keys = append(keys, k)
+	}
+
+	cons, err := s.bus.Consume(s.queue, s.opts.ConsumeOptions, keys...)
+	if err != nil {
+		return fmt.Errorf("consume %s: %w", s.queue, err)
+	}
+
+	s.cons = cons
+	s.stopped = make(chan struct{})
+	go func() {
+		defer close(s.stopped)
+		cons.Serve(func(d amqp.Delivery) {
+			s.settle(cons, d, s.dispatch(d))
+		})
+		cons.Close()
+		log.Printf("%s: stopped", s.queue)
+	}()
+	return nil
+}
+
+// Stop takes no new deliveries and returns once those being handled have
+// been settled.
+func (s *Subscriber) Stop() {
+	if s.cons == nil {
+		return
+	}
+	s.cons.Cancel()
+	<-s.stopped
+}
+
+func (s *Subscriber) dispatch(d amqp.Delivery) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+
+	// dispatch on the event type rather than the routing key, which is
+	// the queue name for deliveries coming back from a retry queue
+	env, err := ParseEnvelope(d.Body, RoutingKey(d))
+	if err != nil {
+		return Permanent(fmt.Errorf("parse envelope: %w", err))
+	}
+	handle, ok := s.handlers[env.Type]
+	if !ok {
+		return Permanent(fmt.Errorf("no handler for %q", env.Type))
+	}
+	if env.ID == "" {
+		env.ID = d.MessageId
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.opts.Timeout)
+	defer cancel()
+	return handle(ctx, env)
+}
+
+// settler is the part of a Consumer that settle needs.
+type settler interface {
+	Retry(d amqp.Delivery) error
+	Reject(d amqp.Delivery) error
+}
+
+func (s *Subscriber) settle(cons settler, d amqp.Delivery, err error) {
+	switch {
+	case err == nil:
+		_ = d.Ack(false)
+	case IsPermanent(err):
+		log.Printf("%s: reject %s %s: %v", s.queue, RoutingKey(d), d.MessageId, err)
+		_ = cons.Reject(d)
+	default:
+		log.Printf("%s: retry %s %s: %v", s.queue, RoutingKey(d), d.MessageId, err)
+		if err := cons.Retry(d); err != nil {
+			log.Printf("%s: %v", s.queue, err)
+		}
+	}
+}
+
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying; the delivery goes straight to
+// the dead-letter queue.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
@@ -0,0 +1,167 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// stubConsumer stands in for a Consumer, recording how each delivery was
+// settled.
+type stubConsumer struct {
+	settled []string
+}
+
+func (c *stubConsumer) Retry(d amqp.Delivery) error {
+	c.settled = append(c.settled, "retry")
+	return nil
+}
+
+func (c *stubConsumer) Reject(d amqp.Delivery) error {
+	c.settled = append(c.settled, "reject")
+	return nil
+}
+
+// stubAcknowledger records acks made on the delivery itself with c.
+type stubAcknowledger struct{ c *stubConsumer }
+
+func (a stubAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.c.settled = append(a.c.settled, "ack")
+	return nil
+}
+
+func (a stubAcknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	a.c.settled = append(a.c.settled, "nack")
+	return nil
+}
+
+func (a stubAcknowledger) Reject(tag uint64, requeue bool) error {
+	a.c.settled = append(a.c.settled, "nack")
+	return nil
+}
+
+func TestSubscriberSettle(t *testing.T) {
+	envelope := func(eventType string, version int, data string) []byte {
+		b, err := json.Marshal(Envelope{
+			SpecVersion: SpecVersion,
+			ID:          "m1",
+			Type:        eventType,
+			DataVersion: version,
+			Data:        json.RawMessage(data),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	tests := []struct {
+		name       string
+		delivery   amqp.Delivery
+		handlerErr error
+		panics     bool
+		want       string
+		wantID     string
+	}{
+		{
+			name:     "handled",
+			delivery: amqp.Delivery{RoutingKey: EventCustomerDeleted, Body: envelope(EventCustomerDeleted, 1, `{"id":"c1"}`)},
+			want:     "ack",
+			wantID:   "m1",
+		},
+		{
+			name:       "permanent error",
+			delivery:   amqp.Delivery{RoutingKey: EventCustomerDeleted, Body: envelope(EventCustomerDeleted, 1, `{"id":"c1"}`)},
+			handlerErr: Permanent(errors.New("no such customer")),
+			want:       "reject",
+			wantID:     "m1",
+		},
+		{
+			name:       "retryable error",
+			delivery:   amqp.Delivery{RoutingKey: EventCustomerDeleted, Body: envelope(EventCustomerDeleted, 1, `{"id":"c1"}`)},
+			handlerErr: errors.New("database is down"),
+			want:       "retry",
+			wantID:     "m1",
+		},
+		{
+			name:     "handler panics",
+			delivery: amqp.Delivery{RoutingKey: EventCustomerDeleted, Body: envelope(EventCustomerDeleted, 1, `{"id":"c1"}`)},
+			panics:   true,
+			want:     "retry",
+			wantID:   "m1",
+		},
+		{
+			name:     "unknown type",
+			delivery: amqp.Delivery{RoutingKey: EventCustomerDeleted, Body: envelope("customer.renamed", 1, `{"id":"c1"}`)},
+			want:     "reject",
+		},
+		{
+			name:     "not JSON",
+			delivery: amqp.Delivery{RoutingKey: EventCustomerDeleted, Body: []byte("not json")},
+			want:     "reject",
+		},
+		{
+			name:     "data does not decode",
+			delivery: amqp.Delivery{RoutingKey: EventCustomerDeleted, Body: envelope(EventCustomerDeleted, 1, `{"id":42}`)},
+			want:     "reject",
+		},
+		{
+			name:     "newer data version",
+			delivery: amqp.Delivery{RoutingKey: EventCustomerDeleted, Body: envelope(EventCustomerDeleted, 9, `{"id":"c1"}`)},
+			want:     "retry",
+		},
+		{
+			name: "legacy bare payload",
+			delivery: amqp.Delivery{
+				RoutingKey: EventCustomerDeleted,
+				MessageId:  "legacy-1",
+				Body:       []byte(`{"id":"c1"}`),
+			},
+			want:   "ack",
+			wantID: "legacy-1",
+		},
+		{
+			name: "legacy bare payload back from a retry queue",
+			delivery: amqp.Delivery{
+				RoutingKey: "orders.customers_cache.v2",
+				Headers:    amqp.Table{routingKeyHeader: EventCustomerDeleted},
+				MessageId:  "legacy-2",
+				Body:       []byte(`{"id":"c1"}`),
+			},
+			want:   "ack",
+			wantID: "legacy-2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotID string
+			s := NewSubscriber(nil, "orders.customers_cache.v2", SubscribeOptions{})
+			Handle(s, EventCustomerDeleted, func(ctx context.Context, env *Envelope, evt CustomerDeleted) error {
+				gotID = env.ID
+				if evt.ID != "c1" {
+					t.Errorf("event = %+v, want customer c1", evt)
+				}
+				if tt.panics {
+					panic("boom")
+				}
+				return tt.handlerErr
+			})
+
+			cons := &stubConsumer{}
+			d := tt.delivery
+			d.Acknowledger = stubAcknowledger{cons}
+			s.settle(cons, d, s.dispatch(d))
+
+			if len(cons.settled) != 1 || cons.settled[0] != tt.want {
+				t.Errorf("settled = %v, want [%s]", cons.settled, tt.want)
+			}
+			if gotID != tt.wantID {
+				t.Errorf("handler saw message ID %q, want %q", gotID, tt.wantID)
+			}
+		})
+	}
+}
@@ -3,27 +3,51 @@ package domain
 import (
 	"context"
 	"time"
+
+	"ecom/internal/common/apperr"
+	"ecom/internal/common/outbox"
+	"ecom/internal/common/page"
+)
+
+var (
+	ErrCustomerNotFound        = apperr.NotFound("customer_not_found", "customer not found")
+	ErrCustomerVersionConflict = apperr.Conflict("customer_version_conflict", "customer has been changed since the given version")
 )
 
 type Customer struct {
 	ID        string    `json:"id"`
 	Name      string    `json:"name"`
 	Email     string    `json:"email"`
+	Version   int64     `json:"version"`
 	UpdatedAt time.Time `json:"updatedAt"`
+	// Set on deleted customers, whose name and email have been erased.
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+// CustomerFilter narrows a customer listing; zero values match everything.
+type CustomerFilter struct {
+	// Email matches case-insensitively.
+	Email string
 }
 
 type Repository interface {
 	SaveWithOutbox(ctx context.Context, c *Customer, msg *OutboxMessage) error
+	// UpdateWithOutbox overwrites the stored customer, which must still be
+	// at version c.Version-1, or fails with ErrCustomerVersionConflict.
+	UpdateWithOutbox(ctx context.Context, c *Customer, msg *OutboxMessage) error
 	Get(ctx context.Context, id string) (*Customer, error)
+	// List pages through the customers sorted by name, email or updatedAt.
+	List(ctx context.Context, f CustomerFilter, r page.Request) (*page.Page[Customer], error)
 
 	// Outbox methods
-	GetUnprocessedMessages(ctx context.Context, limit int) ([]OutboxMessage, error)
-	MarkMessageProcessed(ctx context.Context, id int64) error
+	ClaimMessages(ctx context.Context, limit int, publish func(msgs []OutboxMessage) []OutboxResult) error
+	PruneMessages(ctx context.Context, before time.Time, limit int, archive bool) (int64, error)
+	ListMessages(ctx context.Context, f outbox.Filter) ([]OutboxMessage, error)
+	GetMessage(ctx context.Context, id int64) (*OutboxMessage, error)
+	RequeueMessages(ctx context.Context, ids []int64) (int64, error)
 }
 
-type OutboxMessage struct {
-	ID         int64
-	RoutingKey string
-	Payload    []byte
-	CreatedAt  time.Time
-}
+type (
+	OutboxMessage = outbox.Message
+	OutboxResult  = outbox.Result
+)
@@ -4,7 +4,11 @@ import (
 	"context"
 	"time"
 
+	"ecom/internal/common/apperr"
+	"ecom/internal/common/page"
+	"ecom/internal/customers/domain"
 	"ecom/internal/customers/service"
+
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -17,8 +21,11 @@ func NewCustomerHandler(svc *service.CustomerService) *CustomerHandler {
 }
 
 func (h *CustomerHandler) RegisterRoutes(app fiber.Router) {
+	app.Get("/customers", h.List)
 	app.Post("/customers", h.Create)
 	app.Get("/customers/:id", h.Get)
+	app.Patch("/customers/:id", h.Update)
+	app.Delete("/customers/:id", h.Delete)
 }
 
 func (h *CustomerHandler) Create(c *fiber.Ctx) error {
@@ -27,7 +34,7 @@ func (h *CustomerHandler) Create(c *fiber.Ctx) error {
 		Email string `json:"email"`
 	}
 	if err := c.BodyParser(&in); err != nil {
-		return c.Status(400).SendString(err.Error())
+		return apperr.Validation("invalid_body", "request body is not valid JSON")
 	}
 
 	// Create a context with timeout
@@ -36,25 +43,77 @@ func (h *CustomerHandler) Create(c *fiber.Ctx) error {
 
 	cust, err := h.svc.Create(ctx, in.Name, in.Email)
 	if err != nil {
-		return c.Status(500).SendString(err.Error())
+		return err
 	}
 
 	return c.JSON(cust)
 }
 
+// List accepts limit, cursor, sort (name, email or updatedAt, prefixed with
+// - for descending) and email query parameters.
+func (h *CustomerHandler) List(c *fiber.Ctx) error {
+	f := domain.CustomerFilter{Email: c.Query("email")}
+	r := page.Request{
+		Limit:  c.QueryInt("limit"),
+		Cursor: c.Query("cursor"),
+		Sort:   c.Query("sort"),
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 2*time.Second)
+	defer cancel()
+
+	customers, err := h.svc.List(ctx, f, r)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(customers)
+}
+
 func (h *CustomerHandler) Get(c *fiber.Ctx) error {
 	id := c.Params("id")
-	
+
 	ctx, cancel := context.WithTimeout(c.Context(), 2*time.Second)
 	defer cancel()
 
 	cust, err := h.svc.Get(ctx, id)
 	if err != nil {
-		if err.Error() == "not found" {
-			return c.Status(404).SendString("not found")
-		}
-		return c.Status(500).SendString(err.Error())
+		return err
+	}
+
+	return c.JSON(cust)
+}
+
+// Update changes only the fields present in the body. An optional version
+// must match the stored one.
+func (h *CustomerHandler) Update(c *fiber.Ctx) error {
+	var in struct {
+		Name    *string `json:"name"`
+		Email   *string `json:"email"`
+		Version *int64  `json:"version"`
+	}
+	if err := c.BodyParser(&in); err != nil {
+		return apperr.Validation("invalid_body", "request body is not valid JSON")
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 2*time.Second)
+	defer cancel()
+
+	cust, err := h.svc.Update(ctx, c.Params("id"), service.CustomerChanges(in))
+	if err != nil {
+		return err
 	}
 
 	return c.JSON(cust)
-}
\ No newline at end of file
+}
+
+func (h *CustomerHandler) Delete(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.Context(), 2*time.Second)
+	defer cancel()
+
+	if err := h.svc.Delete(ctx, c.Params("id")); err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
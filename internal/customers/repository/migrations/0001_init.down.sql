@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS outbox_archive;
+DROP TABLE IF EXISTS outbox;
+DROP TABLE IF EXISTS customers;
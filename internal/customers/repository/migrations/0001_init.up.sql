@@ -0,0 +1,51 @@
+-- Baseline schema. Everything is IF NOT EXISTS so databases set up by the
+-- old initSchema are adopted as they are; the ALTERs at the bottom bring
+-- the ones from older builds up to date.
+
+CREATE TABLE IF NOT EXISTS customers (
+  id TEXT PRIMARY KEY,
+  name TEXT NOT NULL,
+  email TEXT NOT NULL,
+  version BIGINT NOT NULL DEFAULT 1,
+  updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS outbox (
+  id BIGSERIAL PRIMARY KEY,
+  message_id TEXT NOT NULL DEFAULT gen_random_uuid()::text,
+  routing_key TEXT NOT NULL,
+  payload JSONB NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  processed_at TIMESTAMP,
+  attempts INT NOT NULL DEFAULT 0,
+  last_error TEXT,
+  next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  quarantined_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (id)
+  WHERE processed_at IS NULL AND quarantined_at IS NULL;
+CREATE INDEX IF NOT EXISTS outbox_processed_at_idx ON outbox (processed_at)
+  WHERE processed_at IS NOT NULL;
+
+-- pruned messages land here when the pruner runs in archive mode;
+-- monthly partitions are created on demand
+CREATE TABLE IF NOT EXISTS outbox_archive (
+  id BIGINT NOT NULL,
+  routing_key TEXT NOT NULL,
+  payload JSONB NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  processed_at TIMESTAMP NOT NULL,
+  attempts INT NOT NULL,
+  last_error TEXT,
+  archived_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  message_id TEXT
+) PARTITION BY RANGE (processed_at);
+
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS attempts INT NOT NULL DEFAULT 0;
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS last_error TEXT;
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW();
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS quarantined_at TIMESTAMP;
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS message_id TEXT NOT NULL DEFAULT gen_random_uuid()::text;
+ALTER TABLE outbox_archive ADD COLUMN IF NOT EXISTS message_id TEXT;
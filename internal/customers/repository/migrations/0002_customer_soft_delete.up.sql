@@ -0,0 +1 @@
+ALTER TABLE customers ADD COLUMN deleted_at TIMESTAMP;
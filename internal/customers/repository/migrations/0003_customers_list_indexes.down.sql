@@ -0,0 +1,4 @@
+DROP INDEX customers_name_idx;
+DROP INDEX customers_email_idx;
+DROP INDEX customers_updated_at_idx;
+DROP INDEX customers_email_lower_idx;
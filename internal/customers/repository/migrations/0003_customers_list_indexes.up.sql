@@ -0,0 +1,5 @@
+-- keyset pagination for GET /customers, one index per sort
+CREATE INDEX customers_name_idx ON customers (name, id) WHERE deleted_at IS NULL;
+CREATE INDEX customers_email_idx ON customers (email, id) WHERE deleted_at IS NULL;
+CREATE INDEX customers_updated_at_idx ON customers (updated_at, id) WHERE deleted_at IS NULL;
+CREATE INDEX customers_email_lower_idx ON customers (lower(email)) WHERE deleted_at IS NULL;
@@ -0,0 +1,13 @@
+-- responses to POST requests sent with an Idempotency-Key, replayed on
+-- retries; status is NULL while the first request is still running
+CREATE TABLE idempotency_keys (
+  key TEXT PRIMARY KEY,
+  request_hash TEXT NOT NULL,
+  status INT,
+  content_type TEXT,
+  response BYTEA,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  completed_at TIMESTAMP
+);
+
+CREATE INDEX idempotency_keys_created_at_idx ON idempotency_keys (created_at);
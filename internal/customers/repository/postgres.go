@@ -3,16 +3,25 @@ package repository
 import (
 	"context"
 	"database/sql"
-	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"ecom/internal/common/outbox"
+	"ecom/internal/common/page"
 	"ecom/internal/customers/domain"
 )
 
 type PostgresRepository struct {
+	*outbox.PostgresStore
 	db *sql.DB
 }
 
 func NewPostgresRepository(db *sql.DB) *PostgresRepository {
-	return &PostgresRepository{db: db}
+	return &PostgresRepository{
+		PostgresStore: outbox.NewPostgresStore(db),
+		db:            db,
+	}
 }
 
 func (r *PostgresRepository) SaveWithOutbox(ctx context.Context, c *domain.Customer, msg *domain.OutboxMessage) error {
@@ -23,61 +32,114 @@ func (r *PostgresRepository) SaveWithOutbox(ctx context.Context, c *domain.Custo
 	defer tx.Rollback()
 
 	_, err = tx.ExecContext(ctx,
-		`INSERT INTO customers(id, name, email, updated_at) VALUES ($1,$2,$3,$4)`,
-		c.ID, c.Name, c.Email, c.UpdatedAt,
+		`INSERT INTO customers(id, name, email, version, updated_at) VALUES ($1,$2,$3,$4,$5)`,
+		c.ID, c.Name, c.Email, c.Version, c.UpdatedAt,
 	)
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.ExecContext(ctx,
-		`INSERT INTO outbox(routing_key, payload) VALUES ($1,$2)`,
-		msg.RoutingKey, msg.Payload,
-	)
-	if err != nil {
+	if err := outbox.Enqueue(ctx, tx, msg); err != nil {
 		return err
 	}
 
 	return tx.Commit()
 }
 
-func (r *PostgresRepository) GetUnprocessedMessages(ctx context.Context, limit int) ([]domain.OutboxMessage, error) {
-	rows, err := r.db.QueryContext(ctx,
-		`SELECT id, routing_key, payload, created_at FROM outbox WHERE processed_at IS NULL ORDER BY created_at ASC LIMIT $1`,
-		limit,
-	)
+func (r *PostgresRepository) UpdateWithOutbox(ctx context.Context, c *domain.Customer, msg *domain.OutboxMessage) error {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	var msgs []domain.OutboxMessage
-	for rows.Next() {
-		var m domain.OutboxMessage
-		if err := rows.Scan(&m.ID, &m.RoutingKey, &m.Payload, &m.CreatedAt); err != nil {
-			return nil, err
-		}
-		msgs = append(msgs, m)
+	res, err := tx.ExecContext(ctx, `
+UPDATE customers
+SET name = $2, email = $3, version = $4, updated_at = $5, deleted_at = $6
+WHERE id = $1 AND version = $4 - 1 AND deleted_at IS NULL
+`, c.ID, c.Name, c.Email, c.Version, c.UpdatedAt, c.DeletedAt)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return domain.ErrCustomerVersionConflict
+	}
+
+	if err := outbox.Enqueue(ctx, tx, msg); err != nil {
+		return err
 	}
-	return msgs, nil
-}
 
-func (r *PostgresRepository) MarkMessageProcessed(ctx context.Context, id int64) error {
-	_, err := r.db.ExecContext(ctx, `UPDATE outbox SET processed_at = NOW() WHERE id = $1`, id)
-	return err
+	return tx.Commit()
 }
 
 func (r *PostgresRepository) Get(ctx context.Context, id string) (*domain.Customer, error) {
 	var c domain.Customer
 	err := r.db.QueryRowContext(ctx,
-		`SELECT id, name, email FROM customers WHERE id=$1`, id,
-	).Scan(&c.ID, &c.Name, &c.Email)
+		`SELECT id, name, email, version, updated_at FROM customers WHERE id=$1 AND deleted_at IS NULL`, id,
+	).Scan(&c.ID, &c.Name, &c.Email, &c.Version, &c.UpdatedAt)
 
 	if err == sql.ErrNoRows {
-		return nil, errors.New("not found")
+		return nil, domain.ErrCustomerNotFound
 	}
 	if err != nil {
 		return nil, err
 	}
 	return &c, nil
 }
+
+var customerSorts = map[string]page.Column{
+	"name":      {Name: "name", Type: "text"},
+	"email":     {Name: "email", Type: "text"},
+	"updatedAt": {Name: "updated_at", Type: "timestamp"},
+}
+
+func (r *PostgresRepository) List(ctx context.Context, f domain.CustomerFilter, pr page.Request) (*page.Page[domain.Customer], error) {
+	q, err := page.Parse(pr, customerSorts, "name")
+	if err != nil {
+		return nil, err
+	}
+
+	where := []string{"deleted_at IS NULL"}
+	var args []any
+	if f.Email != "" {
+		args = append(args, f.Email)
+		where = append(where, fmt.Sprintf("lower(email) = lower($%d)", len(args)))
+	}
+	if seek, seekArgs := q.Seek(len(args) + 1); seek != "" {
+		args = append(args, seekArgs...)
+		where = append(where, seek)
+	}
+
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, name, email, version, updated_at FROM customers WHERE `+strings.Join(where, " AND ")+q.OrderBy(),
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []domain.Customer
+	for rows.Next() {
+		var c domain.Customer
+		if err := rows.Scan(&c.ID, &c.Name, &c.Email, &c.Version, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return page.Finish(q, out, func(c domain.Customer) (string, string) {
+		switch q.Column.Name {
+		case "email":
+			return c.Email, c.ID
+		case "updated_at":
+			return c.UpdatedAt.Format(time.RFC3339Nano), c.ID
+		}
+		return c.Name, c.ID
+	}), nil
+}
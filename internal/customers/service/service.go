@@ -2,14 +2,20 @@ package service
 
 import (
 	"context"
-	"encoding/json"
+	"net/mail"
 	"time"
 
 	"ecom/internal/common"
+	"ecom/internal/common/apperr"
+	"ecom/internal/common/outbox"
+	"ecom/internal/common/page"
 	"ecom/internal/customers/domain"
 	"github.com/google/uuid"
 )
 
+// source identifies this service in the events it emits.
+const source = "customers"
+
 type CustomerService struct {
 	repo domain.Repository
 }
@@ -27,20 +33,23 @@ func (s *CustomerService) Create(ctx context.Context, name, email string) (*doma
 		ID:        id,
 		Name:      name,
 		Email:     email,
+		Version:   1,
 		UpdatedAt: now,
 	}
+	if err := validate(c); err != nil {
+		return nil, err
+	}
 
 	evt := common.CustomerUpserted{
 		ID:        id,
 		Name:      name,
 		Email:     email,
+		Version:   1,
 		UpdatedAt: now,
 	}
-	payload, _ := json.Marshal(evt)
-
-	msg := &domain.OutboxMessage{
-		RoutingKey: "customer.upserted",
-		Payload:    payload,
+	msg, err := outbox.NewEvent(source, common.EventCustomerUpserted, id, evt)
+	if err != nil {
+		return nil, err
 	}
 
 	if err := s.repo.SaveWithOutbox(ctx, c, msg); err != nil {
@@ -50,6 +59,98 @@ func (s *CustomerService) Create(ctx context.Context, name, email string) (*doma
 	return c, nil
 }
 
+// CustomerChanges is a partial update; nil fields are left as they are.
+// If Version is set the update only applies to that version of the
+// customer.
+type CustomerChanges struct {
+	Name    *string
+	Email   *string
+	Version *int64
+}
+
+func (s *CustomerService) Update(ctx context.Context, id string, ch CustomerChanges) (*domain.Customer, error) {
+	c, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if ch.Version != nil && *ch.Version != c.Version {
+		return nil, domain.ErrCustomerVersionConflict
+	}
+	if ch.Name != nil {
+		c.Name = *ch.Name
+	}
+	if ch.Email != nil {
+		c.Email = *ch.Email
+	}
+	if err := validate(c); err != nil {
+		return nil, err
+	}
+	c.Version++
+	c.UpdatedAt = time.Now().UTC()
+
+	evt := common.CustomerUpserted{
+		ID:        c.ID,
+		Name:      c.Name,
+		Email:     c.Email,
+		Version:   c.Version,
+		UpdatedAt: c.UpdatedAt,
+	}
+	msg, err := outbox.NewEvent(source, common.EventCustomerUpserted, c.ID, evt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.UpdateWithOutbox(ctx, c, msg); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Delete erases a customer's personal data, keeping the row as a
+// tombstone, and announces it with customer.deleted.
+func (s *CustomerService) Delete(ctx context.Context, id string) error {
+	c, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	c.Name = ""
+	c.Email = ""
+	c.Version++
+	c.UpdatedAt = now
+	c.DeletedAt = &now
+
+	evt := common.CustomerDeleted{
+		ID:        c.ID,
+		Version:   c.Version,
+		DeletedAt: now,
+	}
+	msg, err := outbox.NewEvent(source, common.EventCustomerDeleted, c.ID, evt)
+	if err != nil {
+		return err
+	}
+
+	return s.repo.UpdateWithOutbox(ctx, c, msg)
+}
+
+func (s *CustomerService) List(ctx context.Context, f domain.CustomerFilter, r page.Request) (*page.Page[domain.Customer], error) {
+	return s.repo.List(ctx, f, r)
+}
+
 func (s *CustomerService) Get(ctx context.Context, id string) (*domain.Customer, error) {
 	return s.repo.Get(ctx, id)
 }
+
+func validate(c *domain.Customer) error {
+	var fields []apperr.FieldError
+	if c.Name == "" {
+		fields = append(fields, apperr.FieldError{Field: "name", Message: "is required"})
+	}
+	if _, err := mail.ParseAddress(c.Email); err != nil {
+		fields = append(fields, apperr.FieldError{Field: "email", Message: "must be an email address"})
+	}
+	if len(fields) > 0 {
+		return apperr.Validation("invalid_customer", "customer is invalid", fields...)
+	}
+	return nil
+}
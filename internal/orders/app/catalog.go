@@ -0,0 +1,38 @@
+package app
+
+import (
+	"context"
+
+	"ecom/internal/catalog/client"
+	"ecom/internal/orders/domain"
+)
+
+// CatalogProducts is a domain.ProductSource backed by the catalog's HTTP
+// API.
+type CatalogProducts struct {
+	client *client.Client
+}
+
+func NewCatalogProducts(c *client.Client) *CatalogProducts {
+	return &CatalogProducts{client: c}
+}
+
+func (p *CatalogProducts) FetchProducts(ctx context.Context, ids []string) ([]domain.ProductCache, error) {
+	batch, err := p.client.GetBatch(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]domain.ProductCache, len(batch.Products))
+	for i, prod := range batch.Products {
+		out[i] = domain.ProductCache{
+			ID:        prod.ID,
+			Title:     prod.Title,
+			SKU:       prod.SKU,
+			Price:     prod.Price,
+			Version:   prod.Version,
+			UpdatedAt: prod.UpdatedAt,
+		}
+	}
+	return out, nil
+}
@@ -2,90 +2,164 @@ package app
 
 import (
 	"context"
-	"encoding/json"
 	"log"
-	"time"
+	"sync/atomic"
 
 	"ecom/internal/common"
 	"ecom/internal/orders/domain"
 	"ecom/internal/orders/service"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	DefaultPrefetch = 50
+	DefaultWorkers  = 8
 )
 
 type Consumers struct {
-	svc *service.OrderService
-	bus *common.Bus
+	svc         *service.OrderService
+	bus         *common.Bus
+	subscribers []*common.Subscriber
+
+	// Each queue gets Prefetch unacked deliveries handled by Workers
+	// goroutines. Events about the same aggregate stay on one worker and
+	// are handled in order.
+	Prefetch int
+	Workers  int
+
+	// events dropped because the cache already held a newer version
+	staleCustomers atomic.Int64
+	staleProducts  atomic.Int64
 }
 
 func NewConsumers(svc *service.OrderService, bus *common.Bus) *Consumers {
-	return &Consumers{svc: svc, bus: bus}
+	return &Consumers{
+		svc:      svc,
+		bus:      bus,
+		Prefetch: DefaultPrefetch,
+		Workers:  DefaultWorkers,
+	}
 }
 
-func (c *Consumers) Start() {
-	go c.consumeCustomers()
-	go c.consumeProducts()
+func (c *Consumers) options(replaces ...string) common.SubscribeOptions {
+	return common.SubscribeOptions{
+		ConsumeOptions: common.ConsumeOptions{
+			Prefetch: c.Prefetch,
+			Workers:  c.Workers,
+			Key:      subject,
+			Replaces: replaces,
+		},
+	}
 }
 
-func (c *Consumers) consumeCustomers() {
-	cons, err := c.bus.Consume("orders.customers_cache", "customer.upserted")
-	if err != nil {
-		log.Fatalf("consume customers: %v", err)
-	}
+func (c *Consumers) Start() error {
+	// the .v2 queues carry dead-letter arguments the original durable
+	// queues were declared without; those are drained into them
+	customers := common.NewSubscriber(c.bus, "orders.customers_cache.v2", c.options("orders.customers_cache"))
+	common.Handle(customers, common.EventCustomerUpserted,
+		once(NewInbox(c.svc, "orders.customers_cache.v2"), c.customerUpserted))
+	common.Handle(customers, common.EventCustomerDeleted,
+		once(NewInbox(c.svc, "orders.customers_cache.v2"), c.customerDeleted))
 
-	for d := range cons.Deliveries {
-		var evt common.CustomerUpserted
-		if err := json.Unmarshal(d.Body, &evt); err != nil {
-			log.Printf("bad customer event: %v", err)
-			_ = d.Nack(false, false)
-			continue
+	products := common.NewSubscriber(c.bus, "orders.products_cache.v2", c.options("orders.products_cache"))
+	common.Handle(products, common.EventProductUpserted,
+		once(NewInbox(c.svc, "orders.products_cache.v2"), c.productUpserted))
+	common.Handle(products, common.EventProductDeleted,
+		once(NewInbox(c.svc, "orders.products_cache.v2"), c.productDeleted))
+
+	for _, s := range []*common.Subscriber{customers, products} {
+		if err := s.Start(); err != nil {
+			c.Stop()
+			return err
 		}
+		c.subscribers = append(c.subscribers, s)
+	}
+	return nil
+}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-		err := c.svc.UpdateCustomerCache(ctx, &domain.CustomerCache{
-			ID:        evt.ID,
-			Name:      evt.Name,
-			Email:     evt.Email,
-			UpdatedAt: evt.UpdatedAt,
-		})
-		cancel()
+// Stop waits for the deliveries in flight to be settled.
+func (c *Consumers) Stop() {
+	for _, s := range c.subscribers {
+		s.Stop()
+	}
+}
 
-		if err != nil {
-			log.Printf("cache customer upsert failed: %v", err)
-			_ = d.Nack(false, true)
-			continue
-		}
-		_ = d.Ack(false)
+func (c *Consumers) customerUpserted(ctx context.Context, tx *service.OrderService, evt common.CustomerUpserted) error {
+	applied, err := tx.UpdateCustomerCache(ctx, &domain.CustomerCache{
+		ID:        evt.ID,
+		Name:      evt.Name,
+		Email:     evt.Email,
+		Version:   evt.Version,
+		UpdatedAt: evt.UpdatedAt,
+	})
+	if err != nil {
+		return err
 	}
+	if !applied {
+		n := c.staleCustomers.Add(1)
+		log.Printf("skip stale customer event %s v%d (%d stale so far)", evt.ID, evt.Version, n)
+	}
+	return nil
 }
 
-func (c *Consumers) consumeProducts() {
-	cons, err := c.bus.Consume("orders.products_cache", "product.upserted")
+func (c *Consumers) customerDeleted(ctx context.Context, tx *service.OrderService, evt common.CustomerDeleted) error {
+	applied, err := tx.AnonymiseCustomer(ctx, evt.ID, evt.Version, evt.DeletedAt)
 	if err != nil {
-		log.Fatalf("consume products: %v", err)
+		return err
+	}
+	if !applied {
+		n := c.staleCustomers.Add(1)
+		log.Printf("skip stale customer deletion %s v%d (%d stale so far)", evt.ID, evt.Version, n)
 	}
+	return nil
+}
 
-	for d := range cons.Deliveries {
-		var evt common.ProductUpserted
-		if err := json.Unmarshal(d.Body, &evt); err != nil {
-			log.Printf("bad product event: %v", err)
-			_ = d.Nack(false, false)
-			continue
-		}
+func (c *Consumers) productUpserted(ctx context.Context, tx *service.OrderService, evt common.ProductUpserted) error {
+	applied, err := tx.UpdateProductCache(ctx, &domain.ProductCache{
+		ID:        evt.ID,
+		Title:     evt.Title,
+		SKU:       evt.SKU,
+		Price:     evt.Price,
+		Version:   evt.Version,
+		UpdatedAt: evt.UpdatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	if !applied {
+		n := c.staleProducts.Add(1)
+		log.Printf("skip stale product event %s v%d (%d stale so far)", evt.ID, evt.Version, n)
+	}
+	return nil
+}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-		err := c.svc.UpdateProductCache(ctx, &domain.ProductCache{
-			ID:        evt.ID,
-			Title:     evt.Title,
-			SKU:       evt.SKU,
-			Price:     evt.Price,
-			UpdatedAt: evt.UpdatedAt,
+func (c *Consumers) productDeleted(ctx context.Context, tx *service.OrderService, evt common.ProductDeleted) error {
+	applied, err := tx.DiscontinueProduct(ctx, evt.ID, evt.Version, evt.DeletedAt)
+	if err != nil {
+		return err
+	}
+	if !applied {
+		n := c.staleProducts.Add(1)
+		log.Printf("skip stale product deletion %s v%d (%d stale so far)", evt.ID, evt.Version, n)
+	}
+	return nil
+}
+
+// once adapts fn into a handler that runs at most once per message ID.
+func once[T any](inbox *Inbox, fn func(ctx context.Context, tx *service.OrderService, evt T) error) common.HandlerFunc[T] {
+	return func(ctx context.Context, env *common.Envelope, evt T) error {
+		return inbox.Process(ctx, env.ID, func(ctx context.Context, tx *service.OrderService) error {
+			return fn(ctx, tx, evt)
 		})
-		cancel()
+	}
+}
 
-		if err != nil {
-			log.Printf("cache product upsert failed: %v", err)
-			_ = d.Nack(false, true)
-			continue
-		}
-		_ = d.Ack(false)
+// subject keys deliveries by the aggregate their event is about.
+func subject(d amqp.Delivery) string {
+	env, err := common.ParseEnvelope(d.Body, common.RoutingKey(d))
+	if err != nil {
+		return ""
 	}
+	return env.Subject
 }
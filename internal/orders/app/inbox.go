@@ -0,0 +1,62 @@
+package app
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"ecom/internal/common"
+	"ecom/internal/orders/service"
+)
+
+// Inbox makes event handlers safe to replay. The message ID of every
+// event is recorded in the inbox table in the same transaction as the
+// handler's writes, so a redelivered or republished event is acknowledged
+// without running the handler again, and a failed handler leaves no record
+// behind.
+type Inbox struct {
+	svc      *service.OrderService
+	consumer string
+}
+
+func NewInbox(svc *service.OrderService, consumer string) *Inbox {
+	return &Inbox{svc: svc, consumer: consumer}
+}
+
+// Process runs fn once per message ID. fn must do all of its writes through
+// the service it is given.
+func (i *Inbox) Process(ctx context.Context, messageID string, fn func(ctx context.Context, tx *service.OrderService) error) error {
+	if messageID == "" {
+		// published before message IDs existed; nothing to deduplicate on
+		return fn(ctx, i.svc)
+	}
+
+	return i.svc.WithTx(ctx, func(tx *service.OrderService) error {
+		fresh, err := tx.RecordInboxMessage(ctx, i.consumer, messageID)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			log.Printf("%s: skip duplicate message %s", i.consumer, messageID)
+			return nil
+		}
+		return fn(ctx, tx)
+	})
+}
+
+const (
+	DefaultInboxRetention = 7 * 24 * time.Hour
+	DefaultInboxBatchSize = 1000
+	DefaultInboxInterval  = time.Hour
+)
+
+// NewInboxPruner returns a pruner that periodically forgets inbox entries
+// older than its Retention. Retention only has to outlast redeliveries:
+// messages requeued from an outbox come back under a new ID.
+func NewInboxPruner(svc *service.OrderService) *common.Pruner {
+	p := common.NewPruner("inbox", svc.PruneInbox)
+	p.Retention = DefaultInboxRetention
+	p.BatchSize = DefaultInboxBatchSize
+	p.Interval = DefaultInboxInterval
+	return p
+}
@@ -3,6 +3,15 @@ package domain
 import (
 	"context"
 	"time"
+
+	"ecom/internal/common/apperr"
+	"ecom/internal/common/outbox"
+	"ecom/internal/common/page"
+)
+
+var (
+	ErrOrderNotFound     = apperr.NotFound("order_not_found", "order not found")
+	ErrCustomerNotCached = apperr.NotFound("customer_not_cached", "customer not known to orders")
 )
 
 type Order struct {
@@ -10,6 +19,7 @@ type Order struct {
 	CustomerID string      `json:"customerId"`
 	CreatedAt  time.Time   `json:"createdAt"`
 	Status     string      `json:"status"`
+	Version    int64       `json:"version"` // starts at 1, bumped on every status change
 	Items      []OrderItem `json:"items"`
 }
 
@@ -21,11 +31,20 @@ type OrderItem struct {
 
 // Read Models / Caches
 
+// Caches carry the version and timestamp of the event they were last
+// written from; an upsert only applies if the stored (version, updated_at)
+// is lower than the incoming pair, compared as a row: a higher version wins
+// even with an older updated_at, and updated_at only breaks ties.
+
 type CustomerCache struct {
 	ID        string
 	Name      string
 	Email     string
+	Version   int64
 	UpdatedAt time.Time
+	// Set once the customer has been deleted; Name and Email are then
+	// empty.
+	DeletedAt *time.Time
 }
 
 type ProductCache struct {
@@ -33,7 +52,10 @@ type ProductCache struct {
 	Title     string
 	SKU       string
 	Price     int64
+	Version   int64
 	UpdatedAt time.Time
+	// Set once the product has been deleted from the catalog.
+	DiscontinuedAt *time.Time
 }
 
 type OrderView struct {
@@ -41,16 +63,82 @@ type OrderView struct {
 		ID        string    `json:"id"`
 		CreatedAt time.Time `json:"createdAt"`
 		Status    string    `json:"status"`
+		Version   int64     `json:"version"`
 		Customer  any       `json:"customer"`
 	} `json:"order"`
 	Items []any `json:"items"`
 }
 
+// OrderFilter narrows an order listing; zero values match everything.
+type OrderFilter struct {
+	Status     string
+	CustomerID string
+	// Creation time range, From inclusive and To exclusive.
+	From time.Time
+	To   time.Time
+}
+
+// ProductSource fetches products straight from the catalog, for when their
+// events haven't reached products_cache yet.
+type ProductSource interface {
+	// FetchProducts returns the products found among ids.
+	FetchProducts(ctx context.Context, ids []string) ([]ProductCache, error)
+}
+
 type Repository interface {
+	// WithTx runs fn with a repository whose calls share one transaction,
+	// committed when fn returns nil.
+	WithTx(ctx context.Context, fn func(repo Repository) error) error
+
+	// SaveOrder stores a new order and the first entry of its history.
 	SaveOrder(ctx context.Context, o *Order) error
+	GetOrder(ctx context.Context, id string) (*Order, error)
+	// ChangeOrderStatus records ch, failing with ErrOrderVersionConflict
+	// unless the order is still at version ch.Version-1.
+	ChangeOrderStatus(ctx context.Context, orderID string, ch StatusChange) error
+	GetStatusHistory(ctx context.Context, orderID string) ([]StatusChange, error)
 	GetOrderView(ctx context.Context, orderID string) (*OrderView, error)
-	
-	// Cache updates
-	UpsertCustomerCache(ctx context.Context, c *CustomerCache) error
-	UpsertProductCache(ctx context.Context, p *ProductCache) error
+	// ListOrders pages through orders, with their items, by createdAt.
+	ListOrders(ctx context.Context, f OrderFilter, r page.Request) (*page.Page[Order], error)
+
+	// RecordInboxMessage remembers that consumer handled messageID and
+	// reports false if it already had.
+	RecordInboxMessage(ctx context.Context, consumer, messageID string) (bool, error)
+	// PruneInbox deletes up to limit inbox entries processed before the
+	// cutoff, oldest first, and returns how many went.
+	PruneInbox(ctx context.Context, before time.Time, limit int) (int64, error)
+
+	// GetCustomerCache fails with ErrCustomerNotCached if the customer
+	// hasn't been seen.
+	GetCustomerCache(ctx context.Context, id string) (*CustomerCache, error)
+	// GetProductCaches returns the cached products among ids, discontinued
+	// ones included, in no particular order.
+	GetProductCaches(ctx context.Context, ids []string) ([]ProductCache, error)
+
+	// Cache updates. They report false when the stored row is already newer.
+	UpsertCustomerCache(ctx context.Context, c *CustomerCache) (bool, error)
+	// AnonymiseCustomerCache erases a deleted customer's name and email as
+	// of version, leaving a row behind like DiscontinueProductCache.
+	AnonymiseCustomerCache(ctx context.Context, id string, version int64, at time.Time) (bool, error)
+	UpsertProductCache(ctx context.Context, p *ProductCache) (bool, error)
+	// DiscontinueProductCache marks a product deleted as of version. If the
+	// product isn't cached yet it leaves a row behind so a late upsert
+	// can't bring it back.
+	DiscontinueProductCache(ctx context.Context, id string, version int64, at time.Time) (bool, error)
+
+	// Enqueue adds msg to the outbox. Call it through WithTx so the message
+	// commits with the changes it announces.
+	Enqueue(ctx context.Context, msg *OutboxMessage) error
+
+	// Outbox methods
+	ClaimMessages(ctx context.Context, limit int, publish func(msgs []OutboxMessage) []OutboxResult) error
+	PruneMessages(ctx context.Context, before time.Time, limit int, archive bool) (int64, error)
+	ListMessages(ctx context.Context, f outbox.Filter) ([]OutboxMessage, error)
+	GetMessage(ctx context.Context, id int64) (*OutboxMessage, error)
+	RequeueMessages(ctx context.Context, ids []int64) (int64, error)
 }
+
+type (
+	OutboxMessage = outbox.Message
+	OutboxResult  = outbox.Result
+)
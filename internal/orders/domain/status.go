@@ -0,0 +1,82 @@
+package domain
+
+import (
+	"fmt"
+	"time"
+
+	"ecom/internal/common/apperr"
+)
+
+// Order statuses. An order starts CREATED and moves along
+//
+//	CREATED -> CONFIRMED -> PAID -> SHIPPED -> DELIVERED
+//
+// It can be CANCELLED until it is paid, and REFUNDED once paid or
+// delivered. CANCELLED, REFUNDED and DELIVERED are final, apart from
+// refunding a delivered order.
+const (
+	StatusCreated   = "CREATED"
+	StatusConfirmed = "CONFIRMED"
+	StatusPaid      = "PAID"
+	StatusShipped   = "SHIPPED"
+	StatusDelivered = "DELIVERED"
+	StatusCancelled = "CANCELLED"
+	StatusRefunded  = "REFUNDED"
+)
+
+// Actions move an order between statuses.
+const (
+	ActionConfirm = "confirm"
+	ActionPay     = "pay"
+	ActionShip    = "ship"
+	ActionDeliver = "deliver"
+	ActionCancel  = "cancel"
+	ActionRefund  = "refund"
+)
+
+// transitions maps each action to the statuses it applies to and the
+// status it leads to.
+var transitions = map[string]map[string]string{
+	ActionConfirm: {StatusCreated: StatusConfirmed},
+	ActionPay:     {StatusConfirmed: StatusPaid},
+	ActionShip:    {StatusPaid: StatusShipped},
+	ActionDeliver: {StatusShipped: StatusDelivered},
+	ActionCancel:  {StatusCreated: StatusCancelled, StatusConfirmed: StatusCancelled},
+	ActionRefund:  {StatusPaid: StatusRefunded, StatusDelivered: StatusRefunded},
+}
+
+var ErrOrderVersionConflict = apperr.Conflict("order_version_conflict", "order has been changed since the given version")
+
+// StatusChange is one entry of an order's status history. From is empty
+// for the entry recording the order's creation.
+type StatusChange struct {
+	From    string    `json:"from,omitempty"`
+	To      string    `json:"to"`
+	Version int64     `json:"version"`
+	Reason  string    `json:"reason,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// Apply performs action on o, bumping its version, and returns the change
+// to record.
+func (o *Order) Apply(action, reason string, at time.Time) (StatusChange, error) {
+	next, ok := transitions[action]
+	if !ok {
+		return StatusChange{}, apperr.Validation("unknown_action", fmt.Sprintf("unknown order action %q", action))
+	}
+	to, ok := next[o.Status]
+	if !ok {
+		return StatusChange{}, apperr.Conflict("invalid_transition", fmt.Sprintf("cannot %s an order that is %s", action, o.Status))
+	}
+
+	ch := StatusChange{
+		From:    o.Status,
+		To:      to,
+		Version: o.Version + 1,
+		Reason:  reason,
+		At:      at,
+	}
+	o.Status = to
+	o.Version = ch.Version
+	return ch, nil
+}
@@ -0,0 +1,106 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"ecom/internal/common/apperr"
+)
+
+var (
+	allStatuses = []string{
+		StatusCreated, StatusConfirmed, StatusPaid, StatusShipped,
+		StatusDelivered, StatusCancelled, StatusRefunded,
+	}
+	allActions = []string{
+		ActionConfirm, ActionPay, ActionShip, ActionDeliver, ActionCancel, ActionRefund,
+	}
+)
+
+func TestApplyAllowed(t *testing.T) {
+	tests := []struct {
+		from, action, to string
+	}{
+		{StatusCreated, ActionConfirm, StatusConfirmed},
+		{StatusConfirmed, ActionPay, StatusPaid},
+		{StatusPaid, ActionShip, StatusShipped},
+		{StatusShipped, ActionDeliver, StatusDelivered},
+		{StatusCreated, ActionCancel, StatusCancelled},
+		{StatusConfirmed, ActionCancel, StatusCancelled},
+		{StatusPaid, ActionRefund, StatusRefunded},
+		{StatusDelivered, ActionRefund, StatusRefunded},
+	}
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, tt := range tests {
+		t.Run(tt.from+"/"+tt.action, func(t *testing.T) {
+			o := &Order{Status: tt.from, Version: 3}
+			ch, err := o.Apply(tt.action, "because", at)
+			if err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+
+			want := StatusChange{From: tt.from, To: tt.to, Version: 4, Reason: "because", At: at}
+			if ch != want {
+				t.Errorf("change = %+v, want %+v", ch, want)
+			}
+			if o.Status != tt.to || o.Version != 4 {
+				t.Errorf("order = %s v%d, want %s v4", o.Status, o.Version, tt.to)
+			}
+		})
+	}
+}
+
+func TestApplyRejected(t *testing.T) {
+	allowed := map[[2]string]bool{
+		{StatusCreated, ActionConfirm}:  true,
+		{StatusConfirmed, ActionPay}:    true,
+		{StatusPaid, ActionShip}:        true,
+		{StatusShipped, ActionDeliver}:  true,
+		{StatusCreated, ActionCancel}:   true,
+		{StatusConfirmed, ActionCancel}: true,
+		{StatusPaid, ActionRefund}:      true,
+		{StatusDelivered, ActionRefund}: true,
+	}
+
+	for _, from := range allStatuses {
+		for _, action := range allActions {
+			if allowed[[2]string{from, action}] {
+				continue
+			}
+			t.Run(from+"/"+action, func(t *testing.T) {
+				o := &Order{Status: from, Version: 3}
+				_, err := o.Apply(action, "", time.Now())
+				assertCode(t, err, apperr.ErrConflict, "invalid_transition")
+				if o.Status != from || o.Version != 3 {
+					t.Errorf("order = %s v%d, want it untouched at %s v3", o.Status, o.Version, from)
+				}
+			})
+		}
+	}
+}
+
+func TestApplyUnknownAction(t *testing.T) {
+	for _, action := range []string{"", "archive", "CONFIRM"} {
+		t.Run(action, func(t *testing.T) {
+			o := &Order{Status: StatusCreated, Version: 1}
+			_, err := o.Apply(action, "", time.Now())
+			assertCode(t, err, apperr.ErrValidation, "unknown_action")
+			if o.Status != StatusCreated || o.Version != 1 {
+				t.Errorf("order = %s v%d, want it untouched", o.Status, o.Version)
+			}
+		})
+	}
+}
+
+func assertCode(t *testing.T, err error, kind error, code string) {
+	t.Helper()
+	var appErr *apperr.Error
+	if !errors.As(err, &appErr) {
+		t.Fatalf("err = %v, want an *apperr.Error", err)
+	}
+	if !errors.Is(err, kind) || appErr.Code != code {
+		t.Errorf("err = %v (code %q), want kind %v with code %q", err, appErr.Code, kind, code)
+	}
+}
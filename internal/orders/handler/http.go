@@ -4,7 +4,11 @@ import (
 	"context"
 	"time"
 
+	"ecom/internal/common/apperr"
+	"ecom/internal/common/page"
+	"ecom/internal/orders/domain"
 	"ecom/internal/orders/service"
+
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -17,8 +21,11 @@ func NewOrderHandler(svc *service.OrderService) *OrderHandler {
 }
 
 func (h *OrderHandler) RegisterRoutes(app fiber.Router) {
+	app.Get("/orders", h.List)
 	app.Post("/orders", h.Create)
 	app.Get("/orders/:id/view", h.GetView)
+	app.Get("/orders/:id/history", h.GetHistory)
+	app.Post("/orders/:id/:action", h.Transition)
 }
 
 func (h *OrderHandler) Create(c *fiber.Ctx) error {
@@ -27,14 +34,10 @@ func (h *OrderHandler) Create(c *fiber.Ctx) error {
 		Items      []struct {
 			ProductID string `json:"productId"`
 			Quantity  int    `json:"quantity"`
-			UnitPrice int64  `json:"unitPrice"`
 		} `json:"items"`
 	}
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(400).SendString(err.Error())
-	}
-	if req.CustomerID == "" || len(req.Items) == 0 {
-		return c.Status(400).SendString("customerId and items required")
+		return apperr.Validation("invalid_body", "request body is not valid JSON")
 	}
 
 	in := service.CreateOrderInput{
@@ -42,13 +45,11 @@ func (h *OrderHandler) Create(c *fiber.Ctx) error {
 		Items: make([]struct {
 			ProductID string
 			Quantity  int
-			UnitPrice int64
 		}, len(req.Items)),
 	}
 	for i, item := range req.Items {
 		in.Items[i].ProductID = item.ProductID
 		in.Items[i].Quantity = item.Quantity
-		in.Items[i].UnitPrice = item.UnitPrice
 	}
 
 	ctx, cancel := context.WithTimeout(c.Context(), 3*time.Second)
@@ -56,12 +57,80 @@ func (h *OrderHandler) Create(c *fiber.Ctx) error {
 
 	id, err := h.svc.CreateOrder(ctx, in)
 	if err != nil {
-		return c.Status(500).SendString(err.Error())
+		return err
 	}
 
 	return c.JSON(map[string]any{"orderId": id})
 }
 
+// List accepts limit, cursor, sort (createdAt or -createdAt, the default),
+// status, customerId, from and to (RFC 3339) query parameters.
+func (h *OrderHandler) List(c *fiber.Ctx) error {
+	f := domain.OrderFilter{
+		Status:     c.Query("status"),
+		CustomerID: c.Query("customerId"),
+	}
+	var err error
+	if f.From, err = queryTime(c, "from"); err != nil {
+		return err
+	}
+	if f.To, err = queryTime(c, "to"); err != nil {
+		return err
+	}
+	r := page.Request{
+		Limit:  c.QueryInt("limit"),
+		Cursor: c.Query("cursor"),
+		Sort:   c.Query("sort"),
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 3*time.Second)
+	defer cancel()
+
+	orders, err := h.svc.ListOrders(ctx, f, r)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(orders)
+}
+
+// Transition performs the action in the path (confirm, pay, ship, deliver,
+// cancel or refund). The body is optional and may carry the version the
+// caller expects the order to be at and a reason for the history.
+func (h *OrderHandler) Transition(c *fiber.Ctx) error {
+	var in struct {
+		Version *int64 `json:"version"`
+		Reason  string `json:"reason"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&in); err != nil {
+			return apperr.Validation("invalid_body", "request body is not valid JSON")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 3*time.Second)
+	defer cancel()
+
+	o, err := h.svc.Transition(ctx, c.Params("id"), c.Params("action"), in.Version, in.Reason)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(o)
+}
+
+func (h *OrderHandler) GetHistory(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.Context(), 3*time.Second)
+	defer cancel()
+
+	history, err := h.svc.GetStatusHistory(ctx, c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(history)
+}
+
 func (h *OrderHandler) GetView(c *fiber.Ctx) error {
 	orderID := c.Params("id")
 
@@ -70,11 +139,20 @@ func (h *OrderHandler) GetView(c *fiber.Ctx) error {
 
 	view, err := h.svc.GetOrderView(ctx, orderID)
 	if err != nil {
-		if err.Error() == "not found" {
-			return c.Status(404).SendString("not found")
-		}
-		return c.Status(500).SendString(err.Error())
+		return err
 	}
 
 	return c.JSON(view)
-}
\ No newline at end of file
+}
+
+func queryTime(c *fiber.Ctx, name string) (time.Time, error) {
+	s := c.Query(name)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, apperr.Validation("invalid_query", "invalid filter", apperr.FieldError{Field: name, Message: "must be an RFC 3339 timestamp"})
+	}
+	return t.UTC(), nil
+}
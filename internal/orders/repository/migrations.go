@@ -0,0 +1,12 @@
+package repository
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migrations holds the schema migrations of orders_db.
+var Migrations, _ = fs.Sub(migrationFiles, "migrations")
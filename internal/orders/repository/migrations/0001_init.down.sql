@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS inbox;
+DROP TABLE IF EXISTS products_cache;
+DROP TABLE IF EXISTS customers_cache;
+DROP TABLE IF EXISTS order_items;
+DROP TABLE IF EXISTS orders;
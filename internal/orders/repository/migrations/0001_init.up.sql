@@ -0,0 +1,47 @@
+-- Baseline schema. Everything is IF NOT EXISTS so databases set up by the
+-- old initSchema are adopted as they are; the ALTERs at the bottom bring
+-- the ones from older builds up to date.
+
+CREATE TABLE IF NOT EXISTS orders (
+  id TEXT PRIMARY KEY,
+  customer_id TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  status TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS order_items (
+  order_id TEXT NOT NULL,
+  product_id TEXT NOT NULL,
+  quantity INT NOT NULL,
+  unit_price BIGINT NOT NULL,
+  PRIMARY KEY(order_id, product_id)
+);
+
+-- local read model caches (for joining)
+CREATE TABLE IF NOT EXISTS customers_cache (
+  id TEXT PRIMARY KEY,
+  name TEXT,
+  email TEXT,
+  version BIGINT NOT NULL DEFAULT 0,
+  updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS products_cache (
+  id TEXT PRIMARY KEY,
+  title TEXT,
+  sku TEXT,
+  price BIGINT,
+  version BIGINT NOT NULL DEFAULT 0,
+  updated_at TIMESTAMP NOT NULL
+);
+
+-- events already handled per consumer, for deduplicating redeliveries
+CREATE TABLE IF NOT EXISTS inbox (
+  consumer TEXT NOT NULL,
+  message_id TEXT NOT NULL,
+  processed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY(consumer, message_id)
+);
+
+ALTER TABLE customers_cache ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE products_cache ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 0;
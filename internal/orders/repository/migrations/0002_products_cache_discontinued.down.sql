@@ -0,0 +1 @@
+ALTER TABLE products_cache DROP COLUMN discontinued_at;
@@ -0,0 +1 @@
+ALTER TABLE products_cache ADD COLUMN discontinued_at TIMESTAMP;
@@ -0,0 +1 @@
+ALTER TABLE customers_cache DROP COLUMN deleted_at;
@@ -0,0 +1 @@
+ALTER TABLE customers_cache ADD COLUMN deleted_at TIMESTAMP;
@@ -0,0 +1,3 @@
+DROP INDEX orders_created_at_idx;
+DROP INDEX orders_customer_created_at_idx;
+DROP INDEX orders_status_created_at_idx;
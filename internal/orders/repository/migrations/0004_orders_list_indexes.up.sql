@@ -0,0 +1,4 @@
+-- keyset pagination for GET /orders, alone or filtered by customer or status
+CREATE INDEX orders_created_at_idx ON orders (created_at, id);
+CREATE INDEX orders_customer_created_at_idx ON orders (customer_id, created_at, id);
+CREATE INDEX orders_status_created_at_idx ON orders (status, created_at, id);
@@ -0,0 +1,3 @@
+DROP TABLE order_status_history;
+ALTER TABLE orders DROP CONSTRAINT orders_status_check;
+ALTER TABLE orders DROP COLUMN version;
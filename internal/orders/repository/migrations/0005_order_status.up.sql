@@ -0,0 +1,20 @@
+ALTER TABLE orders ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE orders ADD CONSTRAINT orders_status_check
+  CHECK (status IN ('CREATED', 'CONFIRMED', 'PAID', 'SHIPPED', 'DELIVERED', 'CANCELLED', 'REFUNDED'));
+
+CREATE TABLE order_status_history (
+  id BIGSERIAL PRIMARY KEY,
+  order_id TEXT NOT NULL REFERENCES orders(id),
+  from_status TEXT,
+  to_status TEXT NOT NULL,
+  version BIGINT NOT NULL,
+  reason TEXT NOT NULL DEFAULT '',
+  changed_at TIMESTAMP NOT NULL,
+  -- a second writer of the same version loses even if it got past the
+  -- version check on orders
+  UNIQUE (order_id, version)
+);
+
+-- existing orders start their history with their creation
+INSERT INTO order_status_history(order_id, to_status, version, changed_at)
+SELECT id, status, 1, created_at FROM orders;
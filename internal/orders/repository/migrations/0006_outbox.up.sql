@@ -0,0 +1,30 @@
+-- same outbox as catalog and customers, for the order events
+CREATE TABLE outbox (
+  id BIGSERIAL PRIMARY KEY,
+  message_id TEXT NOT NULL DEFAULT gen_random_uuid()::text,
+  routing_key TEXT NOT NULL,
+  payload JSONB NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  processed_at TIMESTAMP,
+  attempts INT NOT NULL DEFAULT 0,
+  last_error TEXT,
+  next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  quarantined_at TIMESTAMP
+);
+
+CREATE INDEX outbox_pending_idx ON outbox (id)
+  WHERE processed_at IS NULL AND quarantined_at IS NULL;
+CREATE INDEX outbox_processed_at_idx ON outbox (processed_at)
+  WHERE processed_at IS NOT NULL;
+
+CREATE TABLE outbox_archive (
+  id BIGINT NOT NULL,
+  routing_key TEXT NOT NULL,
+  payload JSONB NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  processed_at TIMESTAMP NOT NULL,
+  attempts INT NOT NULL,
+  last_error TEXT,
+  archived_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  message_id TEXT
+) PARTITION BY RANGE (processed_at);
@@ -3,100 +3,357 @@ package repository
 import (
 	"context"
 	"database/sql"
-	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"ecom/internal/common/outbox"
+	"ecom/internal/common/page"
 	"ecom/internal/orders/domain"
 )
 
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 type PostgresRepository struct {
+	*outbox.PostgresStore
 	db *sql.DB
+	q  querier // db, or the transaction of a repository handed out by WithTx
 }
 
 func NewPostgresRepository(db *sql.DB) *PostgresRepository {
-	return &PostgresRepository{db: db}
+	return &PostgresRepository{
+		PostgresStore: outbox.NewPostgresStore(db),
+		db:            db,
+		q:             db,
+	}
 }
 
-func (r *PostgresRepository) SaveOrder(ctx context.Context, o *domain.Order) error {
+func (r *PostgresRepository) WithTx(ctx context.Context, fn func(repo domain.Repository) error) error {
+	return r.inTx(ctx, func(tx *PostgresRepository) error {
+		return fn(tx)
+	})
+}
+
+// inTx runs fn against a repository bound to a transaction, joining the
+// current one if r is already inside one.
+func (r *PostgresRepository) inTx(ctx context.Context, fn func(tx *PostgresRepository) error) error {
+	if _, ok := r.q.(*sql.Tx); ok {
+		return fn(r)
+	}
+
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	_, err = tx.ExecContext(ctx,
-		`INSERT INTO orders(id, customer_id, created_at, status) VALUES ($1,$2,$3,'CREATED')`,
-		o.ID, o.CustomerID, o.CreatedAt,
-	)
-	if err != nil {
+	if err := fn(&PostgresRepository{PostgresStore: r.PostgresStore, db: r.db, q: tx}); err != nil {
 		return err
 	}
+	return tx.Commit()
+}
 
-	for _, it := range o.Items {
-		_, err = tx.ExecContext(ctx,
-			`INSERT INTO order_items(order_id, product_id, quantity, unit_price)
-			 VALUES ($1,$2,$3,$4)`,
-			o.ID, it.ProductID, it.Quantity, it.UnitPrice,
+func (r *PostgresRepository) SaveOrder(ctx context.Context, o *domain.Order) error {
+	return r.inTx(ctx, func(tx *PostgresRepository) error {
+		_, err := tx.q.ExecContext(ctx,
+			`INSERT INTO orders(id, customer_id, created_at, status, version) VALUES ($1,$2,$3,$4,$5)`,
+			o.ID, o.CustomerID, o.CreatedAt, o.Status, o.Version,
 		)
 		if err != nil {
 			return err
 		}
+		if err := tx.recordStatus(ctx, o.ID, domain.StatusChange{To: o.Status, Version: o.Version, At: o.CreatedAt}); err != nil {
+			return err
+		}
+
+		for _, it := range o.Items {
+			_, err = tx.q.ExecContext(ctx,
+				`INSERT INTO order_items(order_id, product_id, quantity, unit_price)
+				 VALUES ($1,$2,$3,$4)`,
+				o.ID, it.ProductID, it.Quantity, it.UnitPrice,
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *PostgresRepository) Enqueue(ctx context.Context, msg *domain.OutboxMessage) error {
+	return r.inTx(ctx, func(tx *PostgresRepository) error {
+		return outbox.Enqueue(ctx, tx.q, msg)
+	})
+}
+
+func (r *PostgresRepository) GetOrder(ctx context.Context, id string) (*domain.Order, error) {
+	var o domain.Order
+	err := r.q.QueryRowContext(ctx,
+		`SELECT id, customer_id, created_at, status, version FROM orders WHERE id = $1`, id,
+	).Scan(&o.ID, &o.CustomerID, &o.CreatedAt, &o.Status, &o.Version)
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrOrderNotFound
 	}
-	return tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	orders := []domain.Order{o}
+	if err := r.loadItems(ctx, orders); err != nil {
+		return nil, err
+	}
+	return &orders[0], nil
+}
+
+func (r *PostgresRepository) ChangeOrderStatus(ctx context.Context, orderID string, ch domain.StatusChange) error {
+	return r.inTx(ctx, func(tx *PostgresRepository) error {
+		res, err := tx.q.ExecContext(ctx,
+			`UPDATE orders SET status = $2, version = $3 WHERE id = $1 AND status = $4 AND version = $3 - 1`,
+			orderID, ch.To, ch.Version, ch.From,
+		)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return domain.ErrOrderVersionConflict
+		}
+		return tx.recordStatus(ctx, orderID, ch)
+	})
+}
+
+func (r *PostgresRepository) recordStatus(ctx context.Context, orderID string, ch domain.StatusChange) error {
+	_, err := r.q.ExecContext(ctx, `
+INSERT INTO order_status_history(order_id, from_status, to_status, version, reason, changed_at)
+VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6)
+`, orderID, ch.From, ch.To, ch.Version, ch.Reason, ch.At)
+	return err
+}
+
+func (r *PostgresRepository) GetStatusHistory(ctx context.Context, orderID string) ([]domain.StatusChange, error) {
+	rows, err := r.q.QueryContext(ctx, `
+SELECT COALESCE(from_status, ''), to_status, version, reason, changed_at
+FROM order_status_history
+WHERE order_id = $1
+ORDER BY version
+`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []domain.StatusChange
+	for rows.Next() {
+		var ch domain.StatusChange
+		if err := rows.Scan(&ch.From, &ch.To, &ch.Version, &ch.Reason, &ch.At); err != nil {
+			return nil, err
+		}
+		out = append(out, ch)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// every order has at least the entry for its creation
+	if len(out) == 0 {
+		return nil, domain.ErrOrderNotFound
+	}
+	return out, nil
+}
+
+func (r *PostgresRepository) RecordInboxMessage(ctx context.Context, consumer, messageID string) (bool, error) {
+	res, err := r.q.ExecContext(ctx,
+		`INSERT INTO inbox(consumer, message_id) VALUES ($1,$2) ON CONFLICT DO NOTHING`,
+		consumer, messageID,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (r *PostgresRepository) PruneInbox(ctx context.Context, before time.Time, limit int) (int64, error) {
+	res, err := r.q.ExecContext(ctx, `
+WITH batch AS (
+  SELECT consumer, message_id FROM inbox
+  WHERE processed_at < $1
+  ORDER BY processed_at
+  LIMIT $2
+  FOR UPDATE SKIP LOCKED
+)
+DELETE FROM inbox i USING batch b
+WHERE i.consumer = b.consumer AND i.message_id = b.message_id`,
+		before.UTC(), limit,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (r *PostgresRepository) GetCustomerCache(ctx context.Context, id string) (*domain.CustomerCache, error) {
+	var (
+		c           domain.CustomerCache
+		name, email sql.NullString
+		deletedAt   sql.NullTime
+	)
+	err := r.q.QueryRowContext(ctx,
+		`SELECT id, name, email, version, updated_at, deleted_at FROM customers_cache WHERE id = $1`, id,
+	).Scan(&c.ID, &name, &email, &c.Version, &c.UpdatedAt, &deletedAt)
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrCustomerNotCached
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.Name, c.Email = name.String, email.String
+	if deletedAt.Valid {
+		c.DeletedAt = &deletedAt.Time
+	}
+	return &c, nil
+}
+
+func (r *PostgresRepository) GetProductCaches(ctx context.Context, ids []string) ([]domain.ProductCache, error) {
+	rows, err := r.q.QueryContext(ctx, `
+SELECT id, title, sku, price, version, updated_at, discontinued_at
+FROM products_cache
+WHERE id = ANY($1)
+`, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []domain.ProductCache
+	for rows.Next() {
+		var (
+			p              domain.ProductCache
+			title, sku     sql.NullString
+			price          sql.NullInt64
+			discontinuedAt sql.NullTime
+		)
+		if err := rows.Scan(&p.ID, &title, &sku, &price, &p.Version, &p.UpdatedAt, &discontinuedAt); err != nil {
+			return nil, err
+		}
+		p.Title, p.SKU, p.Price = title.String, sku.String, price.Int64
+		if discontinuedAt.Valid {
+			p.DiscontinuedAt = &discontinuedAt.Time
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
 }
 
-func (r *PostgresRepository) UpsertCustomerCache(ctx context.Context, c *domain.CustomerCache) error {
-	_, err := r.db.ExecContext(ctx, `
-INSERT INTO customers_cache(id, name, email, updated_at)
-VALUES ($1,$2,$3,$4)
+func (r *PostgresRepository) UpsertCustomerCache(ctx context.Context, c *domain.CustomerCache) (bool, error) {
+	res, err := r.q.ExecContext(ctx, `
+INSERT INTO customers_cache(id, name, email, version, updated_at)
+VALUES ($1,$2,$3,$4,$5)
 ON CONFLICT (id) DO UPDATE
 SET name = EXCLUDED.name,
     email = EXCLUDED.email,
+    version = EXCLUDED.version,
     updated_at = EXCLUDED.updated_at
-`, c.ID, c.Name, c.Email, c.UpdatedAt)
-	return err
+WHERE (customers_cache.version, customers_cache.updated_at) < (EXCLUDED.version, EXCLUDED.updated_at)
+`, c.ID, c.Name, c.Email, c.Version, c.UpdatedAt)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
 }
 
-func (r *PostgresRepository) UpsertProductCache(ctx context.Context, p *domain.ProductCache) error {
-	_, err := r.db.ExecContext(ctx, `
-INSERT INTO products_cache(id, title, sku, price, updated_at)
-VALUES ($1,$2,$3,$4,$5)
+func (r *PostgresRepository) AnonymiseCustomerCache(ctx context.Context, id string, version int64, at time.Time) (bool, error) {
+	res, err := r.q.ExecContext(ctx, `
+INSERT INTO customers_cache(id, version, updated_at, deleted_at)
+VALUES ($1,$2,$3,$3)
+ON CONFLICT (id) DO UPDATE
+SET name = NULL,
+    email = NULL,
+    version = EXCLUDED.version,
+    updated_at = EXCLUDED.updated_at,
+    deleted_at = EXCLUDED.deleted_at
+WHERE (customers_cache.version, customers_cache.updated_at) < (EXCLUDED.version, EXCLUDED.updated_at)
+`, id, version, at)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (r *PostgresRepository) UpsertProductCache(ctx context.Context, p *domain.ProductCache) (bool, error) {
+	res, err := r.q.ExecContext(ctx, `
+INSERT INTO products_cache(id, title, sku, price, version, updated_at)
+VALUES ($1,$2,$3,$4,$5,$6)
 ON CONFLICT (id) DO UPDATE
 SET title = EXCLUDED.title,
     sku = EXCLUDED.sku,
     price = EXCLUDED.price,
+    version = EXCLUDED.version,
     updated_at = EXCLUDED.updated_at
-`, p.ID, p.Title, p.SKU, p.Price, p.UpdatedAt)
-	return err
+WHERE (products_cache.version, products_cache.updated_at) < (EXCLUDED.version, EXCLUDED.updated_at)
+`, p.ID, p.Title, p.SKU, p.Price, p.Version, p.UpdatedAt)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (r *PostgresRepository) DiscontinueProductCache(ctx context.Context, id string, version int64, at time.Time) (bool, error) {
+	res, err := r.q.ExecContext(ctx, `
+INSERT INTO products_cache(id, version, updated_at, discontinued_at)
+VALUES ($1,$2,$3,$3)
+ON CONFLICT (id) DO UPDATE
+SET version = EXCLUDED.version,
+    updated_at = EXCLUDED.updated_at,
+    discontinued_at = EXCLUDED.discontinued_at
+WHERE (products_cache.version, products_cache.updated_at) < (EXCLUDED.version, EXCLUDED.updated_at)
+`, id, version, at)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
 }
 
 func (r *PostgresRepository) GetOrderView(ctx context.Context, orderID string) (*domain.OrderView, error) {
 	var out domain.OrderView
 	var customerID string
 	var name, email sql.NullString
+	var deletedAt sql.NullTime
 
-	err := r.db.QueryRowContext(ctx, `
-SELECT o.id, o.created_at, o.status, o.customer_id,
-       c.name, c.email
+	err := r.q.QueryRowContext(ctx, `
+SELECT o.id, o.created_at, o.status, o.version, o.customer_id,
+       c.name, c.email, c.deleted_at
 FROM orders o
 LEFT JOIN customers_cache c ON c.id = o.customer_id
 WHERE o.id = $1
-`, orderID).Scan(&out.Order.ID, &out.Order.CreatedAt, &out.Order.Status, &customerID, &name, &email)
+`, orderID).Scan(&out.Order.ID, &out.Order.CreatedAt, &out.Order.Status, &out.Order.Version, &customerID, &name, &email, &deletedAt)
 
 	if err == sql.ErrNoRows {
-		return nil, errors.New("not found")
+		return nil, domain.ErrOrderNotFound
 	}
 	if err != nil {
 		return nil, err
 	}
 
 	out.Order.Customer = map[string]any{
-		"id":    customerID,
-		"name":  nullToAny(name),
-		"email": nullToAny(email),
+		"id":      customerID,
+		"name":    nullToAny(name),
+		"email":   nullToAny(email),
+		"deleted": deletedAt.Valid,
 	}
 
-	rows, err := r.db.QueryContext(ctx, `
+	rows, err := r.q.QueryContext(ctx, `
 SELECT oi.product_id, oi.quantity, oi.unit_price,
-       p.title, p.sku, p.price
+       p.title, p.sku, p.price, p.discontinued_at
 FROM order_items oi
 LEFT JOIN products_cache p ON p.id = oi.product_id
 WHERE oi.order_id = $1
@@ -113,8 +370,9 @@ WHERE oi.order_id = $1
 			unitPrice  int64
 			title, sku sql.NullString
 			price      sql.NullInt64
+			deletedAt  sql.NullTime
 		)
-		if err := rows.Scan(&productID, &qty, &unitPrice, &title, &sku, &price); err != nil {
+		if err := rows.Scan(&productID, &qty, &unitPrice, &title, &sku, &price, &deletedAt); err != nil {
 			return nil, err
 		}
 		out.Items = append(out.Items, map[string]any{
@@ -122,16 +380,115 @@ WHERE oi.order_id = $1
 			"quantity":  qty,
 			"unitPrice": unitPrice,
 			"product": map[string]any{
-				"id":    productID,
-				"title": nullToAny(title),
-				"sku":   nullToAny(sku),
-				"price": nullToAnyInt64(price),
+				"id":           productID,
+				"title":        nullToAny(title),
+				"sku":          nullToAny(sku),
+				"price":        nullToAnyInt64(price),
+				"discontinued": deletedAt.Valid,
 			},
 		})
 	}
 	return &out, nil
 }
 
+var orderSorts = map[string]page.Column{
+	"createdAt": {Name: "created_at", Type: "timestamp"},
+}
+
+func (r *PostgresRepository) ListOrders(ctx context.Context, f domain.OrderFilter, pr page.Request) (*page.Page[domain.Order], error) {
+	q, err := page.Parse(pr, orderSorts, "-createdAt")
+	if err != nil {
+		return nil, err
+	}
+
+	where := []string{"TRUE"}
+	var args []any
+	if f.Status != "" {
+		args = append(args, f.Status)
+		where = append(where, fmt.Sprintf("status = $%d", len(args)))
+	}
+	if f.CustomerID != "" {
+		args = append(args, f.CustomerID)
+		where = append(where, fmt.Sprintf("customer_id = $%d", len(args)))
+	}
+	if !f.From.IsZero() {
+		args = append(args, f.From)
+		where = append(where, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if !f.To.IsZero() {
+		args = append(args, f.To)
+		where = append(where, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+	if seek, seekArgs := q.Seek(len(args) + 1); seek != "" {
+		args = append(args, seekArgs...)
+		where = append(where, seek)
+	}
+
+	rows, err := r.q.QueryContext(ctx,
+		`SELECT id, customer_id, created_at, status, version FROM orders WHERE `+strings.Join(where, " AND ")+q.OrderBy(),
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []domain.Order
+	for rows.Next() {
+		var o domain.Order
+		if err := rows.Scan(&o.ID, &o.CustomerID, &o.CreatedAt, &o.Status, &o.Version); err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	p := page.Finish(q, out, func(o domain.Order) (string, string) {
+		return o.CreatedAt.Format(time.RFC3339Nano), o.ID
+	})
+	if err := r.loadItems(ctx, p.Items); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// loadItems fills in the items of orders with a single query.
+func (r *PostgresRepository) loadItems(ctx context.Context, orders []domain.Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+	ids := make([]string, len(orders))
+	byID := make(map[string]*domain.Order, len(orders))
+	for i := range orders {
+		ids[i] = orders[i].ID
+		byID[orders[i].ID] = &orders[i]
+		orders[i].Items = []domain.OrderItem{}
+	}
+
+	rows, err := r.q.QueryContext(ctx,
+		`SELECT order_id, product_id, quantity, unit_price FROM order_items WHERE order_id = ANY($1)`, ids,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			orderID string
+			it      domain.OrderItem
+		)
+		if err := rows.Scan(&orderID, &it.ProductID, &it.Quantity, &it.UnitPrice); err != nil {
+			return err
+		}
+		o := byID[orderID]
+		o.Items = append(o.Items, it)
+	}
+	return rows.Err()
+}
+
 func nullToAny(s sql.NullString) any {
 	if s.Valid {
 		return s.String
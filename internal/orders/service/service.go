@@ -2,65 +2,326 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"slices"
 	"time"
 
+	"ecom/internal/common"
+	"ecom/internal/common/apperr"
+	"ecom/internal/common/outbox"
+	"ecom/internal/common/page"
 	"ecom/internal/orders/domain"
 	"github.com/google/uuid"
 )
 
+// source identifies this service in the events it emits.
+const source = "orders"
+
 type OrderService struct {
 	repo domain.Repository
+
+	// Catalog, if set, is asked for products missing from products_cache
+	// when an order is created. What it returns is written to the cache.
+	Catalog domain.ProductSource
 }
 
 func NewOrderService(repo domain.Repository) *OrderService {
 	return &OrderService{repo: repo}
 }
 
+// MaxItemQuantity caps the quantity of a single order line.
+const MaxItemQuantity = 1000
+
 type CreateOrderInput struct {
 	CustomerID string
 	Items      []struct {
 		ProductID string
 		Quantity  int
-		UnitPrice int64
 	}
 }
 
+// CreateOrder prices the items from products_cache. Everything wrong with
+// the input is reported at once in a single validation error.
 func (s *OrderService) CreateOrder(ctx context.Context, in CreateOrderInput) (string, error) {
+	items, err := s.priceItems(ctx, in)
+	if err != nil {
+		return "", err
+	}
+
 	orderID := uuid.NewString()
 	now := time.Now().UTC()
 
-	items := make([]domain.OrderItem, len(in.Items))
-	for i, item := range in.Items {
-		items[i] = domain.OrderItem{
-			ProductID: item.ProductID,
-			Quantity:  item.Quantity,
-			UnitPrice: item.UnitPrice,
-		}
-	}
-
 	order := &domain.Order{
 		ID:         orderID,
 		CustomerID: in.CustomerID,
 		CreatedAt:  now,
-		Status:     "CREATED",
+		Status:     domain.StatusCreated,
+		Version:    1,
 		Items:      items,
 	}
 
-	if err := s.repo.SaveOrder(ctx, order); err != nil {
+	evt := common.OrderCreated{
+		ID:         order.ID,
+		CustomerID: order.CustomerID,
+		Status:     order.Status,
+		Items:      make([]common.OrderLine, len(items)),
+		Version:    order.Version,
+		CreatedAt:  order.CreatedAt,
+	}
+	for i, it := range items {
+		evt.Items[i] = common.OrderLine(it)
+		evt.Total += int64(it.Quantity) * it.UnitPrice
+	}
+	msg, err := outbox.NewEvent(source, common.EventOrderCreated, orderID, evt)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.repo.WithTx(ctx, func(repo domain.Repository) error {
+		if err := repo.SaveOrder(ctx, order); err != nil {
+			return err
+		}
+		return repo.Enqueue(ctx, msg)
+	})
+	if err != nil {
 		return "", err
 	}
 	return orderID, nil
 }
 
+// priceItems checks the customer and items of in against the caches and
+// returns the items with their current prices.
+func (s *OrderService) priceItems(ctx context.Context, in CreateOrderInput) ([]domain.OrderItem, error) {
+	var fields []apperr.FieldError
+	invalid := func(field, msg string) {
+		fields = append(fields, apperr.FieldError{Field: field, Message: msg})
+	}
+
+	if in.CustomerID == "" {
+		invalid("customerId", "is required")
+	} else {
+		c, err := s.repo.GetCustomerCache(ctx, in.CustomerID)
+		switch {
+		case errors.Is(err, domain.ErrCustomerNotCached):
+			invalid("customerId", "unknown customer")
+		case err != nil:
+			return nil, err
+		case c.DeletedAt != nil:
+			invalid("customerId", "customer has been deleted")
+		}
+	}
+	if len(in.Items) == 0 {
+		invalid("items", "is required")
+	}
+
+	ids := make([]string, 0, len(in.Items))
+	for _, it := range in.Items {
+		if it.ProductID != "" {
+			ids = append(ids, it.ProductID)
+		}
+	}
+	products, err := s.lookupProducts(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]domain.OrderItem, 0, len(in.Items))
+	seen := make(map[string]bool, len(in.Items))
+	for i, it := range in.Items {
+		field := fmt.Sprintf("items[%d]", i)
+		if it.Quantity < 1 || it.Quantity > MaxItemQuantity {
+			invalid(field+".quantity", fmt.Sprintf("must be between 1 and %d", MaxItemQuantity))
+		}
+
+		p, ok := products[it.ProductID]
+		switch {
+		case it.ProductID == "":
+			invalid(field+".productId", "is required")
+		case seen[it.ProductID]:
+			invalid(field+".productId", "appears more than once")
+		case !ok:
+			invalid(field+".productId", "unknown product")
+		case p.DiscontinuedAt != nil:
+			invalid(field+".productId", "product is discontinued")
+		default:
+			items = append(items, domain.OrderItem{
+				ProductID: it.ProductID,
+				Quantity:  it.Quantity,
+				UnitPrice: p.Price,
+			})
+		}
+		seen[it.ProductID] = true
+	}
+
+	if len(fields) > 0 {
+		return nil, apperr.Validation("invalid_order", "order is invalid", fields...)
+	}
+	return items, nil
+}
+
+// lookupProducts reads ids from products_cache, falling back to the
+// catalog for the ones it doesn't have.
+func (s *OrderService) lookupProducts(ctx context.Context, ids []string) (map[string]domain.ProductCache, error) {
+	products := make(map[string]domain.ProductCache, len(ids))
+	if len(ids) == 0 {
+		return products, nil
+	}
+	cached, err := s.repo.GetProductCaches(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range cached {
+		products[p.ID] = p
+	}
+
+	var missing []string
+	for _, id := range ids {
+		if _, ok := products[id]; !ok && !slices.Contains(missing, id) {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) == 0 || s.Catalog == nil {
+		return products, nil
+	}
+
+	fetched, err := s.Catalog.FetchProducts(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	for i := range fetched {
+		if _, err := s.repo.UpsertProductCache(ctx, &fetched[i]); err != nil {
+			return nil, err
+		}
+	}
+	// Read back what was written: an event may have beaten us to a row.
+	cached, err = s.repo.GetProductCaches(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range cached {
+		products[p.ID] = p
+	}
+	return products, nil
+}
+
+// Transition applies one of the domain actions to an order. If version is
+// set the order must still be at that version.
+func (s *OrderService) Transition(ctx context.Context, orderID, action string, version *int64, reason string) (*domain.Order, error) {
+	o, err := s.repo.GetOrder(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if version != nil && *version != o.Version {
+		return nil, domain.ErrOrderVersionConflict
+	}
+
+	ch, err := o.Apply(action, reason, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := statusEvents(o, ch)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.repo.WithTx(ctx, func(repo domain.Repository) error {
+		if err := repo.ChangeOrderStatus(ctx, o.ID, ch); err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			if err := repo.Enqueue(ctx, msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// statusEvents announces ch: order.status_changed always, followed by
+// order.cancelled for cancellations.
+func statusEvents(o *domain.Order, ch domain.StatusChange) ([]*domain.OutboxMessage, error) {
+	changed, err := outbox.NewEvent(source, common.EventOrderStatusChanged, o.ID, common.OrderStatusChanged{
+		ID:        o.ID,
+		From:      ch.From,
+		To:        ch.To,
+		Reason:    ch.Reason,
+		Version:   ch.Version,
+		ChangedAt: ch.At,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ch.To != domain.StatusCancelled {
+		return []*domain.OutboxMessage{changed}, nil
+	}
+
+	cancelled, err := outbox.NewEvent(source, common.EventOrderCancelled, o.ID, common.OrderCancelled{
+		ID:          o.ID,
+		CustomerID:  o.CustomerID,
+		Reason:      ch.Reason,
+		Version:     ch.Version,
+		CancelledAt: ch.At,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []*domain.OutboxMessage{changed, cancelled}, nil
+}
+
+func (s *OrderService) GetStatusHistory(ctx context.Context, orderID string) ([]domain.StatusChange, error) {
+	return s.repo.GetStatusHistory(ctx, orderID)
+}
+
 func (s *OrderService) GetOrderView(ctx context.Context, orderID string) (*domain.OrderView, error) {
 	return s.repo.GetOrderView(ctx, orderID)
 }
 
+func (s *OrderService) ListOrders(ctx context.Context, f domain.OrderFilter, r page.Request) (*page.Page[domain.Order], error) {
+	return s.repo.ListOrders(ctx, f, r)
+}
+
+// WithTx runs fn with a service whose repository calls share one
+// transaction.
+func (s *OrderService) WithTx(ctx context.Context, fn func(tx *OrderService) error) error {
+	return s.repo.WithTx(ctx, func(repo domain.Repository) error {
+		return fn(&OrderService{repo: repo, Catalog: s.Catalog})
+	})
+}
+
 // These are called by consumers
-func (s *OrderService) UpdateCustomerCache(ctx context.Context, c *domain.CustomerCache) error {
+func (s *OrderService) RecordInboxMessage(ctx context.Context, consumer, messageID string) (bool, error) {
+	return s.repo.RecordInboxMessage(ctx, consumer, messageID)
+}
+
+func (s *OrderService) PruneInbox(ctx context.Context, before time.Time, limit int) (int64, error) {
+	return s.repo.PruneInbox(ctx, before, limit)
+}
+
+// UpdateCustomerCache reports false if c was older than the cached row and
+// has been ignored.
+func (s *OrderService) UpdateCustomerCache(ctx context.Context, c *domain.CustomerCache) (bool, error) {
 	return s.repo.UpsertCustomerCache(ctx, c)
 }
 
-func (s *OrderService) UpdateProductCache(ctx context.Context, p *domain.ProductCache) error {
+// AnonymiseCustomer reports false if the cached customer was already at a
+// newer version.
+func (s *OrderService) AnonymiseCustomer(ctx context.Context, id string, version int64, at time.Time) (bool, error) {
+	return s.repo.AnonymiseCustomerCache(ctx, id, version, at)
+}
+
+// UpdateProductCache reports false if p was older than the cached row and
+// has been ignored.
+func (s *OrderService) UpdateProductCache(ctx context.Context, p *domain.ProductCache) (bool, error) {
 	return s.repo.UpsertProductCache(ctx, p)
 }
+
+// DiscontinueProduct reports false if the cached product was already at a
+// newer version.
+func (s *OrderService) DiscontinueProduct(ctx context.Context, id string, version int64, at time.Time) (bool, error) {
+	return s.repo.DiscontinueProductCache(ctx, id, version, at)
+}
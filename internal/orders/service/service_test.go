@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"ecom/internal/common"
+	"ecom/internal/common/apperr"
+	"ecom/internal/orders/domain"
+)
+
+// fakeRepo serves the caches from maps and records what CreateOrder
+// writes. Methods the tests don't reach panic on the nil embedded
+// Repository.
+type fakeRepo struct {
+	domain.Repository
+
+	customers map[string]domain.CustomerCache
+	products  map[string]domain.ProductCache
+
+	saved    *domain.Order
+	enqueued []*domain.OutboxMessage
+}
+
+func (r *fakeRepo) WithTx(ctx context.Context, fn func(repo domain.Repository) error) error {
+	return fn(r)
+}
+
+func (r *fakeRepo) GetCustomerCache(ctx context.Context, id string) (*domain.CustomerCache, error) {
+	c, ok := r.customers[id]
+	if !ok {
+		return nil, domain.ErrCustomerNotCached
+	}
+	return &c, nil
+}
+
+func (r *fakeRepo) GetProductCaches(ctx context.Context, ids []string) ([]domain.ProductCache, error) {
+	var out []domain.ProductCache
+	for id, p := range r.products {
+		if slices.Contains(ids, id) {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+func (r *fakeRepo) SaveOrder(ctx context.Context, o *domain.Order) error {
+	r.saved = o
+	return nil
+}
+
+func (r *fakeRepo) Enqueue(ctx context.Context, msg *domain.OutboxMessage) error {
+	r.enqueued = append(r.enqueued, msg)
+	return nil
+}
+
+func newFakeRepo() *fakeRepo {
+	gone := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	return &fakeRepo{
+		customers: map[string]domain.CustomerCache{
+			"c1":   {ID: "c1", Name: "Ada"},
+			"gone": {ID: "gone", DeletedAt: &gone},
+		},
+		products: map[string]domain.ProductCache{
+			"mug":   {ID: "mug", Price: 1299},
+			"plate": {ID: "plate", Price: 450},
+			"old":   {ID: "old", Price: 100, DiscontinuedAt: &gone},
+		},
+	}
+}
+
+type line = struct {
+	ProductID string
+	Quantity  int
+}
+
+func TestPriceItemsRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		in   CreateOrderInput
+		want []apperr.FieldError
+	}{
+		{
+			name: "unknown customer",
+			in:   CreateOrderInput{CustomerID: "nobody", Items: []line{{"mug", 1}}},
+			want: []apperr.FieldError{{Field: "customerId", Message: "unknown customer"}},
+		},
+		{
+			name: "deleted customer",
+			in:   CreateOrderInput{CustomerID: "gone", Items: []line{{"mug", 1}}},
+			want: []apperr.FieldError{{Field: "customerId", Message: "customer has been deleted"}},
+		},
+		{
+			name: "unknown product",
+			in:   CreateOrderInput{CustomerID: "c1", Items: []line{{"mug", 1}, {"vase", 1}}},
+			want: []apperr.FieldError{{Field: "items[1].productId", Message: "unknown product"}},
+		},
+		{
+			name: "discontinued product",
+			in:   CreateOrderInput{CustomerID: "c1", Items: []line{{"old", 1}}},
+			want: []apperr.FieldError{{Field: "items[0].productId", Message: "product is discontinued"}},
+		},
+		{
+			name: "zero quantity",
+			in:   CreateOrderInput{CustomerID: "c1", Items: []line{{"mug", 0}}},
+			want: []apperr.FieldError{{Field: "items[0].quantity", Message: "must be between 1 and 1000"}},
+		},
+		{
+			name: "negative quantity",
+			in:   CreateOrderInput{CustomerID: "c1", Items: []line{{"mug", -2}}},
+			want: []apperr.FieldError{{Field: "items[0].quantity", Message: "must be between 1 and 1000"}},
+		},
+		{
+			name: "duplicate item",
+			in:   CreateOrderInput{CustomerID: "c1", Items: []line{{"mug", 1}, {"plate", 1}, {"mug", 2}}},
+			want: []apperr.FieldError{{Field: "items[2].productId", Message: "appears more than once"}},
+		},
+		{
+			name: "everything reported at once",
+			in:   CreateOrderInput{Items: []line{{"", 0}}},
+			want: []apperr.FieldError{
+				{Field: "customerId", Message: "is required"},
+				{Field: "items[0].quantity", Message: "must be between 1 and 1000"},
+				{Field: "items[0].productId", Message: "is required"},
+			},
+		},
+		{
+			name: "no items",
+			in:   CreateOrderInput{CustomerID: "c1"},
+			want: []apperr.FieldError{{Field: "items", Message: "is required"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := NewOrderService(newFakeRepo())
+			items, err := svc.priceItems(context.Background(), tt.in)
+			if items != nil {
+				t.Errorf("items = %+v, want none", items)
+			}
+			var appErr *apperr.Error
+			if !errors.As(err, &appErr) || !errors.Is(err, apperr.ErrValidation) {
+				t.Fatalf("err = %v, want a validation error", err)
+			}
+			if !slices.Equal(appErr.Fields, tt.want) {
+				t.Errorf("fields = %+v, want %+v", appErr.Fields, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateOrderPricesItems(t *testing.T) {
+	repo := newFakeRepo()
+	svc := NewOrderService(repo)
+
+	id, err := svc.CreateOrder(context.Background(), CreateOrderInput{
+		CustomerID: "c1",
+		Items:      []line{{"mug", 2}, {"plate", 3}},
+	})
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+
+	wantItems := []domain.OrderItem{
+		{ProductID: "mug", Quantity: 2, UnitPrice: 1299},
+		{ProductID: "plate", Quantity: 3, UnitPrice: 450},
+	}
+	if repo.saved == nil || repo.saved.ID != id || !slices.Equal(repo.saved.Items, wantItems) {
+		t.Fatalf("saved order = %+v, want %s with items %+v", repo.saved, id, wantItems)
+	}
+
+	if len(repo.enqueued) != 1 {
+		t.Fatalf("enqueued %d messages, want 1", len(repo.enqueued))
+	}
+	var env common.Envelope
+	if err := json.Unmarshal(repo.enqueued[0].Payload, &env); err != nil {
+		t.Fatal(err)
+	}
+	var evt common.OrderCreated
+	if err := json.Unmarshal(env.Data, &evt); err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(2*1299 + 3*450); evt.Total != want {
+		t.Errorf("total = %d, want %d", evt.Total, want)
+	}
+	if env.Type != common.EventOrderCreated || evt.ID != id || len(evt.Items) != 2 {
+		t.Errorf("event = %s %+v, want %s for order %s with 2 items", env.Type, evt, common.EventOrderCreated, id)
+	}
+}